@@ -0,0 +1,249 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	autoTagThreshold = 0.8
+	autoTagMax       = 5
+	// a tag is learned when it has that many articles with and without it
+	autoTagMinDocs  = 5
+	autoTagTrainSet = 5000
+	autoTagRetrain  = 6 * time.Hour
+	autoTagBackfill = 1000
+	// evidence of longer articles is scaled down to that many stems,
+	// naive Bayes is overconfident on long texts otherwise
+	autoTagNorm = 50
+	stemLen     = 6
+)
+
+// AutoTag is a tag assigned by the classifier, it is kept apart from
+// the tags of the source
+type AutoTag struct {
+	Tag        string  `bson:"tag"`
+	Confidence float64 `bson:"confidence"`
+}
+
+// TagFeedback is a user's vote on an auto tag, written by server
+type TagFeedback struct {
+	Article bson.ObjectId `bson:"article"`
+	Tag     string        `bson:"tag"`
+	Correct bool          `bson:"correct"`
+}
+
+// tagModel is a naive Bayes classifier with one yes/no model per tag
+// over the stems of the title and the text
+type tagModel struct {
+	docs     int
+	tagDocs  map[string]int
+	tagStems map[string]map[string]float64
+	tagTotal map[string]float64
+	stems    map[string]float64
+	total    float64
+}
+
+var tagger struct {
+	sync.RWMutex
+	model *tagModel
+}
+
+// stems returns the distinct words of the text cut to stemLen runes,
+// which is a rough stemming of Russian endings
+func stems(text string) []string {
+	seen := make(map[string]bool)
+	var res []string
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		r := []rune(w)
+		if len(r) <= 3 || !unicode.IsLetter(r[0]) {
+			continue
+		}
+		if len(r) > stemLen {
+			w = string(r[:stemLen])
+		}
+		if !seen[w] {
+			seen[w] = true
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+func newTagModel() *tagModel {
+	return &tagModel{
+		tagDocs:  make(map[string]int),
+		tagStems: make(map[string]map[string]float64),
+		tagTotal: make(map[string]float64),
+		stems:    make(map[string]float64),
+	}
+}
+
+func (m *tagModel) add(words []string, tags []string) {
+	m.docs++
+	for _, w := range words {
+		m.stems[w]++
+	}
+	m.total += float64(len(words))
+	for _, t := range tags {
+		m.tagDocs[t]++
+		if m.tagStems[t] == nil {
+			m.tagStems[t] = make(map[string]float64)
+		}
+		for _, w := range words {
+			m.tagStems[t][w]++
+		}
+		m.tagTotal[t] += float64(len(words))
+	}
+}
+
+// confidence is the probability that an article with words has tag t
+func (m *tagModel) confidence(t string, words []string) float64 {
+	in := m.tagDocs[t]
+	out := m.docs - in
+	v := float64(len(m.stems))
+	score := math.Log(float64(in)) - math.Log(float64(out))
+	var evidence float64
+	for _, w := range words {
+		n, ok := m.stems[w]
+		if !ok {
+			continue
+		}
+		c := m.tagStems[t][w]
+		evidence += math.Log((c+1)/(m.tagTotal[t]+v)) - math.Log((n-c+1)/(m.total-m.tagTotal[t]+v))
+	}
+	if len(words) > autoTagNorm {
+		evidence *= autoTagNorm / float64(len(words))
+	}
+	return 1 / (1 + math.Exp(-(score + evidence)))
+}
+
+// classify returns the tags of the text over autoTagThreshold, the
+// most confident first
+func (m *tagModel) classify(text string) []AutoTag {
+	words := stems(text)
+	var res []AutoTag
+	for t, n := range m.tagDocs {
+		if n < autoTagMinDocs || m.docs-n < autoTagMinDocs {
+			continue
+		}
+		if c := m.confidence(t, words); c >= autoTagThreshold {
+			res = append(res, AutoTag{t, math.Round(c*100) / 100})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Confidence != res[j].Confidence {
+			return res[i].Confidence > res[j].Confidence
+		}
+		return res[i].Tag < res[j].Tag
+	})
+	if len(res) > autoTagMax {
+		res = res[:autoTagMax]
+	}
+	return res
+}
+
+// trainTagModel learns from the latest articles. The labels are the
+// source tags corrected by user feedback: a tag voted wrong more often
+// than right is dropped, one voted right is added.
+func trainTagModel(ds *DataStore) (*tagModel, error) {
+	var articles []Article
+	err := ds.C("Articles").Find(nil).Select(bson.M{"title": 1, "text": 1, "tags": 1}).
+		Sort("-timestamp").Limit(autoTagTrainSet).All(&articles)
+	if err != nil {
+		return nil, err
+	}
+	var feedback []TagFeedback
+	err = ds.C("TagFeedback").Find(nil).All(&feedback)
+	if err != nil {
+		return nil, err
+	}
+	votes := make(map[bson.ObjectId]map[string]int)
+	for _, f := range feedback {
+		if votes[f.Article] == nil {
+			votes[f.Article] = make(map[string]int)
+		}
+		if f.Correct {
+			votes[f.Article][f.Tag]++
+		} else {
+			votes[f.Article][f.Tag]--
+		}
+	}
+	m := newTagModel()
+	for _, a := range articles {
+		var tags []string
+		for _, t := range a.Tags {
+			if votes[a.Id][t] >= 0 {
+				tags = append(tags, t)
+			}
+		}
+		for t, v := range votes[a.Id] {
+			if v > 0 && !in(t, tags) {
+				tags = append(tags, t)
+			}
+		}
+		m.add(stems(a.Title+" "+a.Text), tags)
+	}
+	return m, nil
+}
+
+// autoTags classifies the article with the current model
+func autoTags(a Article) []AutoTag {
+	tagger.RLock()
+	defer tagger.RUnlock()
+	if tagger.model == nil {
+		return nil
+	}
+	return tagger.model.classify(a.Title + " " + a.Text)
+}
+
+// trainTagger retrains the model every autoTagRetrain and tags the
+// recent articles which have no auto tags or entities yet
+func trainTagger() {
+	for {
+		ds := NewDataStore()
+		m, err := trainTagModel(ds)
+		if err != nil {
+			log.Println("tagger train err: ", err)
+		} else {
+			tagger.Lock()
+			tagger.model = m
+			tagger.Unlock()
+			backfillAutoTags(ds)
+			backfillEntities(ds)
+		}
+		ds.Close()
+		time.Sleep(autoTagRetrain)
+	}
+}
+
+func backfillAutoTags(ds *DataStore) {
+	c := ds.C("Articles")
+	var articles []Article
+	err := c.Find(bson.M{"autoTags": bson.M{"$exists": false}}).Select(bson.M{"title": 1, "text": 1}).
+		Sort("-timestamp").Limit(autoTagBackfill).All(&articles)
+	if err != nil {
+		log.Println("auto tags backfill err: ", err)
+		return
+	}
+	for _, a := range articles {
+		tags := autoTags(a)
+		if tags == nil {
+			tags = []AutoTag{}
+		}
+		err := c.UpdateId(a.Id, bson.M{"$set": bson.M{"autoTags": tags}})
+		if err != nil {
+			log.Println("auto tags backfill err: ", err)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	maxKeywords = 10
+	maxEntities = 15
+	// a single word not known to the gazetteers is taken as an entity
+	// when it is met that many times inside sentences
+	entityMinCount  = 2
+	entityBackfill  = 1000
+	entityMaxLength = 4
+)
+
+// Keyword is a word of the article weighted by tf-idf
+type Keyword struct {
+	Word  string  `bson:"word"`
+	Score float64 `bson:"score"`
+}
+
+// Entity is a person, an organization or a place named in the article.
+// Key is the kind and the stemmed words, so that the inflected forms
+// of a name have the same key.
+type Entity struct {
+	Key   string `bson:"key"`
+	Name  string `bson:"name"`
+	Kind  string `bson:"kind"`
+	Count int    `bson:"count"`
+}
+
+const (
+	entityPerson = "person"
+	entityOrg    = "org"
+	entityPlace  = "place"
+)
+
+// stopWords are frequent words which are never keywords, shorter words
+// are dropped anyway
+var stopWords = toSet(`этот этого этой этом этому эти этих этим этими также такой такая такие такого
+которые который которая которое которых которым которого которой когда тогда потом после перед через
+между более менее очень может могут можно нужно будет будут было были была быть есть если чтобы только
+уже ещё еще даже тоже всего всех всем весь вся все свой своя свои своих своей себя самый самая самые
+вчера завтра году года годы лет время раньше сейчас сегодня теперь здесь почему потому однако хотя много часть
+where which there their these those about after before would could should other than then them they
+this that with from have been were will what when also into more some such only your like just`)
+
+// personTitles are the words before a name of a person
+var personTitles = toSet(`президент премьер министр глава директор основатель сооснователь гендиректор
+руководитель председатель профессор академик ученый учёный исследователь космонавт астронавт инженер
+разработчик программист автор журналист писатель сенатор губернатор мэр ceo cto`)
+
+// orgWords are the words before a name of an organization
+var orgWords = toSet(`компания компании компанию компанией корпорация корпорации стартап стартапа
+агентство агентства университет университета институт института фонд фонда банк банка холдинг
+холдинга организация организации проект проекта сервис сервиса`)
+
+// firstNames, places and orgs are small gazetteers, they are keyed by
+// entityStem so that the case forms match
+const firstNameList = `александр алексей анатолий андрей анна антон артём артем борис вадим валентина
+валерий василий виктор виталий владимир владислав галина георгий григорий дарья денис дмитрий евгений
+екатерина елена иван игорь илья ирина кирилл константин леонид максим марина мария михаил наталья
+никита николай олег ольга павел пётр петр роман светлана сергей станислав степан татьяна фёдор федор
+юлия юрий яков илон джефф джон джеймс билл стив марк тим сэм линус дональд джо эндрю питер
+elon jeff john james bill steve mark tim sam linus donald joe andrew peter satya sundar larry sergey`
+
+const placeList = `россия москва санкт-петербург петербург новосибирск екатеринбург казань сочи
+байконур восточный сибирь урал крым украина киев беларусь минск казахстан европа азия африка америка
+сша китай пекин япония токио индия германия берлин франция париж великобритания англия лондон италия
+испания канада израиль турция корея сингапур швейцария нидерланды швеция финляндия польша бразилия
+австралия калифорния техас флорида нью-йорк вашингтон сан-франциско кремниевая силиконовая
+земля луна марс венера меркурий юпитер сатурн уран нептун плутон солнце
+russia moscow china japan india germany france europe london paris berlin california texas`
+
+const orgList = `google гугл microsoft майкрософт apple эппл amazon амазон meta facebook фейсбук
+yandex яндекс сбер сбербанк роскосмос наса nasa esa spacex tesla тесла openai intel amd nvidia
+samsung huawei ibm oracle netflix twitter твиттер github mozilla jetbrains касперский лаборатория
+вконтакте мгу мфти ран cern церн mit stanford`
+
+var (
+	firstNames = stemSet(firstNameList)
+	places     = stemSet(placeList)
+	orgs       = stemSet(orgList)
+	// nominatives are the gazetteer words as they are written, a name
+	// in this form is preferred for display
+	nominatives = toSet(firstNameList + " " + placeList + " " + orgList)
+	placeNames  = stemNames(placeList)
+)
+
+// entityEndings are the case endings cut off by entityStem, the longest
+// first
+var entityEndings = []string{"ией", "ием", "иям", "иях", "ами", "ями", "ого", "его", "ому", "ему",
+	"ой", "ей", "ом", "ем", "ам", "ям", "ах", "ях", "ию", "ия", "ии", "ие", "ью", "ы", "и", "а", "я",
+	"у", "ю", "е", "о", "ь", "й"}
+
+func toSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+func stemSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[entityStem(w)] = true
+	}
+	return set
+}
+
+// stemNames maps the stems of the words to the words in title case
+func stemNames(words string) map[string]string {
+	names := make(map[string]string)
+	for _, w := range strings.Fields(words) {
+		parts := strings.Split(w, "-")
+		for i, p := range parts {
+			r := []rune(p)
+			parts[i] = string(unicode.ToUpper(r[0])) + string(r[1:])
+		}
+		names[entityStem(w)] = strings.Join(parts, "-")
+	}
+	return names
+}
+
+// entityStem lowercases a word of a name and cuts its Russian case
+// ending: Москва, Москвы and Москвой become москв
+func entityStem(w string) string {
+	w = strings.ToLower(w)
+	r := []rune(w)
+	for _, e := range entityEndings {
+		n := len([]rune(e))
+		if len(r)-n >= 3 && strings.HasSuffix(w, e) {
+			return string(r[:len(r)-n])
+		}
+	}
+	return w
+}
+
+// token is a word of the text, start tells that it opens a sentence
+type token struct {
+	word  string
+	start bool
+	// quoted names like «Ростех» are organizations
+	quoted bool
+}
+
+// tokenize splits the text into words, keeping hyphens inside of words,
+// and marks the words after the end of a sentence
+func tokenize(text string) []token {
+	var res []token
+	var cur []rune
+	start, quoted, open := true, false, false
+	flush := func() {
+		w := strings.Trim(string(cur), "-")
+		if w != "" {
+			res = append(res, token{w, start, quoted})
+			start, quoted = false, false
+		}
+		cur = cur[:0]
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-':
+			if len(cur) == 0 {
+				quoted = open
+			}
+			cur = append(cur, r)
+		default:
+			flush()
+			switch r {
+			case '.', '!', '?', '…', '\n':
+				start = true
+			case '«', '“':
+				open = true
+			case '"':
+				// a straight quote both opens and closes
+				open = !open
+			case '»', '”':
+				open = false
+			}
+		}
+	}
+	flush()
+	return res
+}
+
+func capitalized(w string) bool {
+	r := []rune(w)
+	return unicode.IsUpper(r[0])
+}
+
+// acronym is a word in upper case like НАСА or IBM
+func acronym(w string) bool {
+	r := []rune(w)
+	if len(r) < 2 || len(r) > 6 {
+		return false
+	}
+	for _, c := range r {
+		if !unicode.IsUpper(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func latin(w string) bool {
+	for _, c := range w {
+		if unicode.IsLetter(c) && c > unicode.MaxLatin1 {
+			return false
+		}
+	}
+	return true
+}
+
+// surname tells by the ending whether w looks like a Russian surname
+func surname(w string) bool {
+	w = strings.ToLower(w)
+	for _, s := range []string{"ов", "ев", "ёв", "ин", "ын", "ова", "ева", "ина", "ына", "ский", "цкий", "ская",
+		"цкая", "ского", "цкого", "ову", "еву", "ину", "ым", "ович", "евич", "овна", "евна"} {
+		if strings.HasSuffix(w, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// kindOf guesses the kind of the name by the gazetteers, the word before
+// it and the shape of its words, "" means it is not an entity
+func kindOf(words []string, prev string, quoted bool) string {
+	var keys []string
+	for _, w := range words {
+		keys = append(keys, entityStem(w))
+	}
+	whole := strings.Join(keys, " ")
+	switch {
+	case places[whole]:
+		return entityPlace
+	case orgs[whole] || quoted || orgWords[prev]:
+		return entityOrg
+	case firstNames[keys[0]] || personTitles[prev]:
+		return entityPerson
+	case len(words) > 1 && surname(words[len(words)-1]) && !latin(words[0]):
+		return entityPerson
+	case len(words) == 1 && acronym(words[0]):
+		return entityOrg
+	case len(words) > 1 && latin(whole):
+		return entityOrg
+	}
+	return ""
+}
+
+// extractEntities finds the sequences of capitalized words. A word which
+// opens a sentence is taken only when it is known or met capitalized
+// inside of a sentence as well. Names of unknown kind are dropped, and so
+// are single unknown words met less than entityMinCount times.
+func extractEntities(title, text string) []Entity {
+	tokens := tokenize(title + ".\n" + text)
+	inside := make(map[string]bool)
+	for _, t := range tokens {
+		if !t.start && capitalized(t.word) {
+			inside[entityStem(t.word)] = true
+		}
+	}
+	found := make(map[string]*Entity)
+	names := make(map[string]map[string]int)
+	var order []string
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		if !capitalized(t.word) || unicode.IsDigit([]rune(t.word)[0]) {
+			i++
+			continue
+		}
+		j := i + 1
+		// "NASA Билл Нельсон" are two names
+		for j < len(tokens) && j-i < entityMaxLength && !tokens[j].start && capitalized(tokens[j].word) &&
+			latin(tokens[j].word) == latin(t.word) && !acronym(tokens[j].word) && !acronym(t.word) {
+			j++
+		}
+		var words []string
+		for _, w := range tokens[i:j] {
+			words = append(words, w.word)
+		}
+		prev := ""
+		if i > 0 && !t.start {
+			prev = strings.ToLower(tokens[i-1].word)
+		}
+		// the first word of a sentence is skipped alone, the name can
+		// follow it: "Вчера Илон Маск заявил"
+		stem := entityStem(words[0])
+		if t.start && !inside[stem] && !places[stem] && !orgs[stem] && !firstNames[stem] && !acronym(words[0]) {
+			i++
+			continue
+		}
+		kind := kindOf(words, prev, t.quoted)
+		if kind == "" {
+			i = j
+			continue
+		}
+		var keys []string
+		for _, w := range words {
+			keys = append(keys, entityStem(w))
+		}
+		key := kind + ":" + strings.Join(keys, "-")
+		if found[key] == nil {
+			found[key] = &Entity{Key: key, Kind: kind}
+			names[key] = make(map[string]int)
+			order = append(order, key)
+		}
+		found[key].Count++
+		name := strings.Join(words, " ")
+		names[key][name]++
+		if nominatives[strings.ToLower(words[0])] {
+			names[key][name] += len(tokens)
+		}
+		i = j
+	}
+	var res []Entity
+	for _, key := range order {
+		e := found[key]
+		if !strings.Contains(key, "-") && e.Count < entityMinCount && !places[strings.TrimPrefix(key, e.Kind+":")] &&
+			!orgs[strings.TrimPrefix(key, e.Kind+":")] {
+			continue
+		}
+		// the name is the most frequent form, one starting with a
+		// gazetteer word first, and the shortest one on ties
+		best := 0
+		for name, n := range names[key] {
+			if n > best || (n == best && len(name) < len(e.Name)) {
+				best, e.Name = n, name
+			}
+		}
+		// places are written in the nominative of the gazetteer, США
+		// stays in upper case
+		if n := placeNames[strings.TrimPrefix(key, e.Kind+":")]; n != "" && !acronym(e.Name) {
+			e.Name = n
+		}
+		res = append(res, *e)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Count > res[j].Count })
+	if len(res) > maxEntities {
+		res = res[:maxEntities]
+	}
+	return res
+}
+
+// extractKeywords weights the stems of the article by tf-idf, the
+// document frequencies are taken from the tag model. The most frequent
+// form of each stem is returned as the keyword.
+func extractKeywords(title, text string) []Keyword {
+	tf := make(map[string]float64)
+	forms := make(map[string]map[string]int)
+	for _, t := range tokenize(title + ".\n" + text) {
+		w := strings.ToLower(t.word)
+		r := []rune(w)
+		if len(r) <= 3 || !unicode.IsLetter(r[0]) || stopWords[w] {
+			continue
+		}
+		s := w
+		if len(r) > stemLen {
+			s = string(r[:stemLen])
+		}
+		tf[s]++
+		if forms[s] == nil {
+			forms[s] = make(map[string]int)
+		}
+		forms[s][w]++
+	}
+	tagger.RLock()
+	m := tagger.model
+	tagger.RUnlock()
+
+	var res []Keyword
+	for s, n := range tf {
+		idf := 1.0
+		if m != nil && m.docs > 0 {
+			idf = math.Log(float64(m.docs+1) / (m.stems[s] + 1))
+		}
+		if idf <= 0 {
+			continue
+		}
+		word, best := "", 0
+		for f, c := range forms[s] {
+			if c > best || (c == best && f < word) {
+				word, best = f, c
+			}
+		}
+		res = append(res, Keyword{word, math.Round((1+math.Log(n))*idf*100) / 100})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Word < res[j].Word
+	})
+	if len(res) > maxKeywords {
+		res = res[:maxKeywords]
+	}
+	return res
+}
+
+// backfillEntities extracts keywords and entities of the recent articles
+// ingested before the extraction, it runs after the tag model is trained
+// because the keywords need its document frequencies
+func backfillEntities(ds *DataStore) {
+	c := ds.C("Articles")
+	var articles []Article
+	err := c.Find(bson.M{"entities": bson.M{"$exists": false}}).Select(bson.M{"title": 1, "text": 1}).
+		Sort("-timestamp").Limit(entityBackfill).All(&articles)
+	if err != nil {
+		log.Println("entities backfill err: ", err)
+		return
+	}
+	for _, a := range articles {
+		keywords := extractKeywords(a.Title, a.Text)
+		entities := extractEntities(a.Title, a.Text)
+		if keywords == nil {
+			keywords = []Keyword{}
+		}
+		if entities == nil {
+			entities = []Entity{}
+		}
+		err := c.UpdateId(a.Id, bson.M{"$set": bson.M{"keywords": keywords, "entities": entities}})
+		if err != nil {
+			log.Println("entities backfill err: ", err)
+		}
+	}
+}
@@ -27,6 +27,11 @@ type Article struct {
 	NumLinks  int           `bson:"numLinks"`
 	NumImg    int           `bson:"numImg"`
 	Timestamp time.Time     `bson:"timestamp"`
+	Related   []Related     `bson:"related,omitempty"`
+	AutoTags  []AutoTag     `bson:"autoTags,omitempty"`
+	Keywords  []Keyword     `bson:"keywords,omitempty"`
+	Entities  []Entity      `bson:"entities,omitempty"`
+	Summary   string        `bson:"summary"`
 }
 
 type Readability struct {
@@ -117,6 +122,22 @@ func main() {
 	if err != nil {
 		log.Fatal("rabbitmq q err: ", err)
 	}
+	err = rabbitCh.ExchangeDeclare(
+		"articles", // name
+		"fanout",   // type
+		true,       // durable
+		false,      // auto-deleted
+		false,      // internal
+		false,      // no-wait
+		nil,        // arguments
+	)
+	if err != nil {
+		log.Fatal("rabbitmq exchange err: ", err)
+	}
+	err = rabbitCh.QueueBind("butler", "", "articles", false, nil)
+	if err != nil {
+		log.Fatal("rabbitmq bind err: ", err)
+	}
 
 	session, err = mgo.Dial("mongodb://mongo:27017")
 	for err != nil {
@@ -125,6 +146,10 @@ func main() {
 		time.Sleep(time.Second * 5)
 	}
 
+	go backfillRelated()
+	go backfillSummaries()
+	go trainTagger()
+
 	for _, i := range sources {
 		go Handler(i, newItem)
 	}
@@ -150,10 +175,11 @@ func Manager(newItem chan Item) {
 	}
 }
 
+// forBatler announces a new article to the butler and to live feeds
 func forBatler(id bson.ObjectId) {
 	err := rabbitCh.Publish(
+		"articles",
 		"",
-		"butler",
 		false,
 		false,
 		amqp.Publishing{
@@ -234,17 +260,20 @@ func Parser(item Item) {
 	loc, _ := time.LoadLocation("Europe/Moscow")
 	// err = c.Insert(Article{Title: title, Link: item.Url, Source: item.Source.Name, Tags: item.Source.Tags, Text: text, TextLen: textLen,
 	// 	NumLinks: numLinks, NumImg: numImg, Timestamp: time.Now().In(loc), Shingle: shingle, Duplicates: duplicates})
-	err = c.Insert(Article{Title: ra.Title, Link: item.Url, TopImage: ra.TopImage, Source: item.Source.Name, Tags: item.Source.Tags, Text: ra.Text, RawText: ra.RawText,
-		TextLen: len(ra.Text), NumLinks: ra.NumLinks, NumImg: ra.NumImage, Timestamp: time.Now().In(loc)})
+	a := Article{Id: bson.NewObjectId(), Title: ra.Title, Link: item.Url, TopImage: ra.TopImage, Source: item.Source.Name, Tags: canonicalTags(ds, item.Source.Tags), Text: ra.Text, RawText: ra.RawText,
+		TextLen: len(ra.Text), NumLinks: ra.NumLinks, NumImg: ra.NumImage, Timestamp: time.Now().In(loc), Summary: summarize(ra.Text)}
+	a.AutoTags = autoTags(a)
+	a.Keywords = extractKeywords(a.Title, a.Text)
+	a.Entities = extractEntities(a.Title, a.Text)
+	err = c.Insert(a)
 	if err != nil {
 		log.Println("Insert err: ", err)
+		return
 	}
-	var a Article
-	err = c.Find(bson.M{"link": item.Url}).One(&a)
-	if err != nil {
-		log.Println("parser find err: ", err)
-	}
-	// go forBatler(a.Id)
+	go matchSavedSearches(a)
+	go deliverWebhooks(a)
+	go updateRelated(a)
+	go forBatler(a.Id)
 }
 
 // func searchDuplicates(text string, col *mgo.Collection) (shingle []uint32, duplicates []bson.ObjectId) {
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// notification channels of a saved search
+const (
+	NotifyInApp   = "inapp"
+	NotifyEmail   = "email"
+	NotifyWebhook = "webhook"
+)
+
+type Notification struct {
+	Id        bson.ObjectId `bson:"_id,omitempty"`
+	User      bson.ObjectId `bson:"user"`
+	Search    bson.ObjectId `bson:"search"`
+	Name      string        `bson:"name"`
+	Article   bson.ObjectId `bson:"article"`
+	Title     string        `bson:"title"`
+	Link      string        `bson:"link"`
+	Read      bool          `bson:"read"`
+	Timestamp time.Time     `bson:"timestamp"`
+}
+
+// Notifier delivers a notification to a user through one channel
+type Notifier interface {
+	Notify(s SavedSearch, n Notification) error
+}
+
+var notifiers = map[string]Notifier{
+	NotifyInApp:   inAppNotifier{},
+	NotifyEmail:   emailNotifier{addr: os.Getenv("SMTP_ADDR"), from: getEnv("MAIL_FROM", "NeFeed <noreply@nefeed.ga>")},
+	NotifyWebhook: webhookNotifier{client: newPublicClient()},
+}
+
+// errNotPublic is returned by publicDial for internal hosts, retrying
+// won't help
+var errNotPublic = errors.New("address is not public")
+
+// cgnat is the shared address space of carrier NAT, it isn't routed on
+// the internet either
+var _, cgnat, _ = net.ParseCIDR("100.64.0.0/10")
+
+// publicIP reports whether ip is a routable internet address, which
+// rules out loopback, private, link-local and the like
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip))
+}
+
+// publicDial dials user given urls. The address is checked after it is
+// resolved, so neither a name of the docker network like mongo nor a
+// record which resolves to an internal address reaches the services.
+func publicDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil && !strings.Contains(strings.Trim(host, "."), ".") {
+		return nil, fmt.Errorf("host %s: %w", host, errNotPublic)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !publicIP(ip.IP) {
+			return nil, fmt.Errorf("host %s resolves to %s: %w", host, ip.IP, errNotPublic)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("host %s has no address", host)
+	}
+	d := net.Dialer{Timeout: 10 * time.Second}
+	return d.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// newPublicClient is a client for user given urls, redirects are dialed
+// with publicDial as well
+func newPublicClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{DialContext: publicDial}}
+}
+
+// getEnv is os.Getenv with a default, as in server
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// inAppNotifier stores notifications which are shown on the site
+type inAppNotifier struct{}
+
+func (inAppNotifier) Notify(s SavedSearch, n Notification) error {
+	ds := NewDataStore()
+	defer ds.Close()
+	return ds.C("Notifications").Insert(n)
+}
+
+// emailNotifier sends a plain text letter through SMTP_ADDR
+type emailNotifier struct {
+	addr string
+	from string
+}
+
+func (e emailNotifier) Notify(s SavedSearch, n Notification) error {
+	if e.addr == "" {
+		return fmt.Errorf("email notifier: SMTP_ADDR is not set")
+	}
+	ds := NewDataStore()
+	defer ds.Close()
+	var user struct {
+		Email string `bson:"email"`
+	}
+	err := ds.C("Users").FindId(s.User).One(&user)
+	if err != nil {
+		return err
+	}
+	// the name is typed by the user, a line break in it would start a header
+	name := strings.NewReplacer("\r", " ", "\n", " ").Replace(s.Name)
+	from, err := mail.ParseAddress(e.from)
+	if err != nil {
+		return fmt.Errorf("email notifier: MAIL_FROM: %v", err)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n%s\r\n",
+		from, user.Email, mime.QEncoding.Encode("utf-8", "NeFeed: "+name), n.Title, n.Link)
+	return smtp.SendMail(e.addr, nil, from.Address, []string{user.Email}, []byte(msg))
+}
+
+// webhookNotifier posts the notification as json to SavedSearch.Webhook
+type webhookNotifier struct {
+	client *http.Client
+}
+
+func (wh webhookNotifier) Notify(s SavedSearch, n Notification) error {
+	if s.Webhook == "" {
+		return fmt.Errorf("webhook notifier: no url for search %s", s.Id.Hex())
+	}
+	body, err := json.Marshal(struct {
+		Search  string `json:"search"`
+		Article string `json:"article"`
+		Title   string `json:"title"`
+		Link    string `json:"link"`
+	}{s.Name, n.Article.Hex(), n.Title, n.Link})
+	if err != nil {
+		return err
+	}
+	resp, err := wh.client.Post(s.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook notifier: %s answered %s", s.Webhook, resp.Status)
+	}
+	return nil
+}
+
+func notify(s SavedSearch, n Notification) {
+	for _, ch := range s.Notify {
+		nt, ok := notifiers[ch]
+		if !ok {
+			continue
+		}
+		err := nt.Notify(s, n)
+		if err != nil {
+			log.Printf("notify %s err: %v\n", ch, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+)
+
+// Query describes a set of articles, empty fields match everything
+type Query struct {
+	Text    string   `bson:"text" json:"Text"`
+	Sources []string `bson:"sources" json:"Sources"`
+	Tags    []string `bson:"tags" json:"Tags"`
+	// Entities are keys of Entity, the article has to name one of them
+	Entities []string `bson:"entities,omitempty" json:"Entities,omitempty"`
+}
+
+// Match reports whether the article contains every word of q.Text,
+// belongs to one of q.Sources and one of q.Tags and names one of
+// q.Entities
+func (q Query) Match(a Article) bool {
+	if len(q.Sources) > 0 && !in(a.Source, q.Sources) {
+		return false
+	}
+	if len(q.Entities) > 0 {
+		found := false
+		for _, e := range a.Entities {
+			if in(e.Key, q.Entities) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.Tags) > 0 {
+		found := false
+		for _, t := range a.Tags {
+			if in(t, q.Tags) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Text != "" {
+		title := strings.ToLower(a.Title)
+		text := strings.ToLower(a.Text)
+		for _, w := range strings.Fields(strings.ToLower(q.Text)) {
+			if !strings.Contains(title, w) && !strings.Contains(text, w) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func in(s string, list []string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	relatedSize       = 20
+	relatedCandidates = 500
+	relatedWindow     = 14 * 24 * time.Hour
+	relatedMinScore   = 0.25
+
+	// weights of shared tags, text similarity and time proximity
+	relatedTagsWeight = 0.3
+	relatedTextWeight = 0.5
+	relatedTimeWeight = 0.2
+	relatedTimeScale  = 72 * time.Hour
+)
+
+type Related struct {
+	Id    bson.ObjectId `bson:"id"`
+	Score float64       `bson:"score"`
+}
+
+// termVector counts words of the title and the text, short words are skipped
+func termVector(a Article) map[string]float64 {
+	v := make(map[string]float64)
+	words := strings.FieldsFunc(strings.ToLower(a.Title+" "+a.Text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if len([]rune(w)) > 3 {
+			v[w]++
+		}
+	}
+	return v
+}
+
+func cosine(a, b map[string]float64) float64 {
+	var dot, na, nb float64
+	for w, x := range a {
+		na += x * x
+		if y, ok := b[w]; ok {
+			dot += x * y
+		}
+	}
+	for _, y := range b {
+		nb += y * y
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func jaccard(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for _, t := range a {
+		if in(t, b) {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// relatedness combines shared tags, text similarity and time proximity
+func relatedness(a, b Article, va, vb map[string]float64) float64 {
+	dt := math.Abs(a.Timestamp.Sub(b.Timestamp).Hours())
+	return relatedTagsWeight*jaccard(a.Tags, b.Tags) +
+		relatedTextWeight*cosine(va, vb) +
+		relatedTimeWeight*math.Exp(-dt/relatedTimeScale.Hours())
+}
+
+// updateRelated stores the closest recent articles on a and adds a
+// to their lists, so /article/{id}/related is a single read
+func updateRelated(a Article) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Articles")
+
+	var candidates []Article
+	err := c.Find(bson.M{"_id": bson.M{"$ne": a.Id}, "timestamp": bson.M{"$gte": a.Timestamp.Add(-relatedWindow)}}).
+		Sort("-timestamp").Limit(relatedCandidates).All(&candidates)
+	if err != nil {
+		log.Println("related find err: ", err)
+		return
+	}
+	va := termVector(a)
+	var related []Related
+	for _, b := range candidates {
+		score := relatedness(a, b, va, termVector(b))
+		if score < relatedMinScore {
+			continue
+		}
+		related = append(related, Related{b.Id, score})
+		err = c.Update(bson.M{"_id": b.Id, "related.id": bson.M{"$ne": a.Id}}, bson.M{"$push": bson.M{"related": bson.M{
+			"$each":  []Related{{a.Id, score}},
+			"$sort":  bson.M{"score": -1},
+			"$slice": relatedSize,
+		}}})
+		if err != nil && err != mgo.ErrNotFound {
+			log.Println("related update err: ", err)
+		}
+	}
+	sort.Slice(related, func(i, j int) bool { return related[i].Score > related[j].Score })
+	if len(related) > relatedSize {
+		related = related[:relatedSize]
+	}
+	if related == nil {
+		related = []Related{}
+	}
+	err = c.UpdateId(a.Id, bson.M{"$set": bson.M{"related": related}})
+	if err != nil {
+		log.Println("related update err: ", err)
+	}
+}
+
+// backfillRelated computes related lists of recent articles stored
+// before they were precomputed at ingestion
+func backfillRelated() {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	var articles []Article
+	err := ds.C("Articles").Find(bson.M{"related": bson.M{"$exists": false},
+		"timestamp": bson.M{"$gte": time.Now().Add(-relatedWindow)}}).Sort("timestamp").All(&articles)
+	if err != nil {
+		log.Println("related backfill err: ", err)
+		return
+	}
+	for _, a := range articles {
+		updateRelated(a)
+	}
+}
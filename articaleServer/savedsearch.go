@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type SavedSearch struct {
+	Id      bson.ObjectId `bson:"_id,omitempty"`
+	User    bson.ObjectId `bson:"user"`
+	Name    string        `bson:"name"`
+	Query   Query         `bson:"query"`
+	Notify  []string      `bson:"notify"`
+	Webhook string        `bson:"webhook"`
+	Created time.Time     `bson:"created"`
+}
+
+type SearchMatch struct {
+	Id        bson.ObjectId `bson:"_id,omitempty"`
+	Search    bson.ObjectId `bson:"search"`
+	User      bson.ObjectId `bson:"user"`
+	Article   bson.ObjectId `bson:"article"`
+	Timestamp time.Time     `bson:"timestamp"`
+}
+
+// matchSavedSearches runs every saved search against a new article once,
+// so the server only has to read SearchMatches
+func matchSavedSearches(a Article) {
+	ds := NewDataStore()
+	defer ds.Close()
+	cm := ds.C("SearchMatches")
+
+	var searches []SavedSearch
+	err := ds.C("SavedSearches").Find(nil).All(&searches)
+	if err != nil {
+		log.Println("saved searches find err: ", err)
+		return
+	}
+	for _, s := range searches {
+		if !s.Query.Match(a) {
+			continue
+		}
+		err = cm.Insert(SearchMatch{Search: s.Id, User: s.User, Article: a.Id, Timestamp: a.Timestamp})
+		if err != nil {
+			log.Println("search match insert err: ", err)
+			continue
+		}
+		go notify(s, Notification{User: s.User, Search: s.Id, Name: s.Name, Article: a.Id, Title: a.Title,
+			Link: a.Link, Timestamp: a.Timestamp})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// the summary takes the best sentences until it is that long
+	summaryLen       = 300
+	summaryMaxLen    = 600
+	summarySentences = 3
+	// sentences with fewer stems carry no meaning of their own
+	summaryMinStems  = 3
+	textRankDamping  = 0.85
+	textRankIterates = 30
+)
+
+// stopStems are the stop words cut like stems cuts the words
+var stopStems = func() map[string]bool {
+	set := make(map[string]bool)
+	for w := range stopWords {
+		if r := []rune(w); len(r) > stemLen {
+			w = string(r[:stemLen])
+		}
+		set[w] = true
+	}
+	return set
+}()
+
+// abbreviations end with a dot inside of a sentence: "в г. Москве"
+var abbreviations = toSet(`г гг т е др пр им ул д н см ср стр рис млн млрд трлн тыс руб долл`)
+
+// sentences splits the text after . ! ? and … followed by a space and a
+// capital letter, a digit or a quote, and at line breaks
+func sentences(text string) []string {
+	var res []string
+	r := []rune(text)
+	start := 0
+	add := func(end int) {
+		s := strings.TrimSpace(string(r[start:end]))
+		if s != "" {
+			res = append(res, strings.Join(strings.Fields(s), " "))
+		}
+		start = end
+	}
+	for i := 0; i < len(r); i++ {
+		switch r[i] {
+		case '\n':
+			add(i + 1)
+		case '.', '!', '?', '…':
+			j := i + 1
+			for j < len(r) && (r[j] == '.' || r[j] == '!' || r[j] == '?' || r[j] == '»' || r[j] == '"' || r[j] == ')') {
+				j++
+			}
+			if j == len(r) {
+				continue
+			}
+			if !unicode.IsSpace(r[j]) {
+				continue
+			}
+			w := i
+			for w > 0 && unicode.IsLetter(r[w-1]) {
+				w--
+			}
+			if r[i] == '.' && abbreviations[string(r[w:i])] {
+				continue
+			}
+			k := j
+			for k < len(r) && unicode.IsSpace(r[k]) {
+				k++
+			}
+			if k < len(r) && (unicode.IsUpper(r[k]) || unicode.IsDigit(r[k]) || r[k] == '«' || r[k] == '"' || r[k] == '—') {
+				add(j)
+				i = j - 1
+			}
+		}
+	}
+	add(len(r))
+	return res
+}
+
+// textRank scores the sentences by PageRank over a graph where two
+// sentences are linked by the number of their common stems, normalized
+// by the logs of their lengths as in the TextRank paper
+func textRank(words [][]string) []float64 {
+	n := len(words)
+	sets := make([]map[string]bool, n)
+	for i, ws := range words {
+		sets[i] = make(map[string]bool)
+		for _, w := range ws {
+			sets[i][w] = true
+		}
+	}
+	weight := make([][]float64, n)
+	out := make([]float64, n)
+	for i := range weight {
+		weight[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			common := 0
+			for w := range sets[i] {
+				if sets[j][w] {
+					common++
+				}
+			}
+			norm := math.Log(float64(len(sets[i]))) + math.Log(float64(len(sets[j])))
+			if common == 0 || norm <= 0 {
+				continue
+			}
+			weight[i][j] = float64(common) / norm
+			weight[j][i] = weight[i][j]
+			out[i] += weight[i][j]
+			out[j] += weight[i][j]
+		}
+	}
+	score := make([]float64, n)
+	for i := range score {
+		score[i] = 1
+	}
+	for it := 0; it < textRankIterates; it++ {
+		next := make([]float64, n)
+		for i := 0; i < n; i++ {
+			var sum float64
+			for j := 0; j < n; j++ {
+				if weight[j][i] > 0 {
+					sum += weight[j][i] / out[j] * score[j]
+				}
+			}
+			next[i] = 1 - textRankDamping + textRankDamping*sum
+		}
+		score = next
+	}
+	return score
+}
+
+// summarize picks the best sentences of the text by TextRank and joins
+// them in the order of the text
+func summarize(text string) string {
+	var (
+		list  []string
+		words [][]string
+	)
+	for _, s := range sentences(text) {
+		var ws []string
+		for _, w := range stems(s) {
+			if !stopStems[w] {
+				ws = append(ws, w)
+			}
+		}
+		if len(ws) >= summaryMinStems {
+			list = append(list, s)
+			words = append(words, ws)
+		}
+	}
+	if len(list) == 0 {
+		return truncate(strings.Join(strings.Fields(text), " "), summaryLen)
+	}
+	score := textRank(words)
+	order := make([]int, len(list))
+	for i := range order {
+		order[i] = i
+	}
+	// the earlier sentence wins a tie, leads tend to sum the news up
+	sort.SliceStable(order, func(i, j int) bool { return score[order[i]] > score[order[j]] })
+	var picked []int
+	length := 0
+	for _, i := range order {
+		if length >= summaryLen || len(picked) == summarySentences {
+			break
+		}
+		picked = append(picked, i)
+		length += len([]rune(list[i]))
+	}
+	sort.Ints(picked)
+	var parts []string
+	for _, i := range picked {
+		parts = append(parts, list[i])
+	}
+	return truncate(strings.Join(parts, " "), summaryMaxLen)
+}
+
+// truncate cuts s to n runes at a space
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	cut := strings.LastIndex(string(r[:n]), " ")
+	if cut <= 0 {
+		return string(r[:n]) + "…"
+	}
+	return string(r[:n])[:cut] + "…"
+}
+
+// backfillSummaries summarizes the articles ingested before summaries
+func backfillSummaries() {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Articles")
+
+	var a Article
+	iter := c.Find(bson.M{"summary": bson.M{"$exists": false}}).Select(bson.M{"text": 1}).Iter()
+	for iter.Next(&a) {
+		err := c.UpdateId(a.Id, bson.M{"$set": bson.M{"summary": summarize(a.Text)}})
+		if err != nil {
+			log.Println("summary backfill err: ", err)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Println("summary backfill err: ", err)
+	}
+}
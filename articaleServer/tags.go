@@ -0,0 +1,38 @@
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TagDoc is a tag of the taxonomy kept by server
+type TagDoc struct {
+	Slug    string   `bson:"_id"`
+	Aliases []string `bson:"aliases"`
+}
+
+// canonicalTags resolves aliases of the taxonomy to slugs, so sources
+// with old tag names still tag articles right
+func canonicalTags(ds *DataStore, tags []string) []string {
+	var docs []TagDoc
+	ds.C("Tags").Find(bson.M{"aliases": bson.M{"$in": tags}}).All(&docs)
+	slug := make(map[string]string)
+	for _, d := range docs {
+		for _, a := range d.Aliases {
+			slug[a] = d.Slug
+		}
+	}
+	var res []string
+	for _, t := range tags {
+		if s, ok := slug[t]; ok {
+			t = s
+		}
+		found := false
+		for _, r := range res {
+			found = found || r == t
+		}
+		if !found {
+			res = append(res, t)
+		}
+	}
+	return res
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	webhookAttempts    = 5
+	webhookBackoff     = 10 * time.Second
+	webhookMaxFailures = 10
+)
+
+type Webhook struct {
+	Id       bson.ObjectId `bson:"_id,omitempty"`
+	User     bson.ObjectId `bson:"user"`
+	URL      string        `bson:"url"`
+	Secret   string        `bson:"secret"`
+	Filter   Query         `bson:"filter"`
+	Failures int           `bson:"failures"`
+	Disabled bool          `bson:"disabled"`
+	Created  time.Time     `bson:"created"`
+}
+
+type WebhookDelivery struct {
+	Id        bson.ObjectId `bson:"_id,omitempty"`
+	Webhook   bson.ObjectId `bson:"webhook"`
+	Article   bson.ObjectId `bson:"article"`
+	Attempt   int           `bson:"attempt"`
+	Status    int           `bson:"status"`
+	Error     string        `bson:"error"`
+	Duration  time.Duration `bson:"duration"`
+	Timestamp time.Time     `bson:"timestamp"`
+}
+
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	Id        string    `json:"id"`
+	Title     string    `json:"title"`
+	Link      string    `json:"link"`
+	Source    string    `json:"source"`
+	Tags      []string  `json:"tags"`
+	TopImage  string    `json:"topImage"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// webhookClient dials public addresses only, see publicDial
+var webhookClient = newPublicClient()
+
+// deliverWebhooks posts the article to every enabled webhook whose filter matches
+func deliverWebhooks(a Article) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	var hooks []Webhook
+	err := ds.C("Webhooks").Find(bson.M{"disabled": false}).All(&hooks)
+	if err != nil {
+		log.Println("webhooks find err: ", err)
+		return
+	}
+	body, err := json.Marshal(webhookPayload{"article.created", a.Id.Hex(), a.Title, a.Link, a.Source, a.Tags,
+		a.TopImage, a.Timestamp})
+	if err != nil {
+		log.Println("webhook marshal err: ", err)
+		return
+	}
+	for _, h := range hooks {
+		if h.Filter.Match(a) {
+			go deliverWebhook(h, a.Id, body)
+		}
+	}
+}
+
+// deliverWebhook retries with exponential backoff and disables the hook
+// after webhookMaxFailures deliveries in a row have failed. An internal
+// address is not retried.
+func deliverWebhook(h Webhook, article bson.ObjectId, body []byte) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Webhooks")
+	cd := ds.C("WebhookDeliveries")
+
+	delivery := bson.NewObjectId()
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		start := time.Now()
+		status, err := postSigned(h, delivery, body)
+		d := WebhookDelivery{Webhook: h.Id, Article: article, Attempt: attempt, Status: status,
+			Duration: time.Since(start), Timestamp: start}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		cd.Insert(d)
+		if err == nil {
+			c.UpdateId(h.Id, bson.M{"$set": bson.M{"failures": 0}})
+			return
+		}
+		if errors.Is(err, errNotPublic) {
+			break
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	var updated Webhook
+	_, err := c.FindId(h.Id).Apply(mgo.Change{Update: bson.M{"$inc": bson.M{"failures": 1}}, ReturnNew: true}, &updated)
+	if err != nil {
+		log.Println("webhook update err: ", err)
+		return
+	}
+	if updated.Failures >= webhookMaxFailures {
+		c.UpdateId(h.Id, bson.M{"$set": bson.M{"disabled": true}})
+		log.Printf("webhook %s disabled after %d failures\n", h.Id.Hex(), updated.Failures)
+	}
+}
+
+// postSigned sends body with an HMAC-SHA256 signature of "timestamp.body"
+func postSigned(h Webhook, delivery bson.ObjectId, body []byte) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+
+	r, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-NeFeed-Delivery", delivery.Hex())
+	r.Header.Set("X-NeFeed-Timestamp", ts)
+	r.Header.Set("X-NeFeed-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := webhookClient.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// articlePage is the reader view of a single article
+func articlePage(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	id := mux.Vars(req)["id"]
+	var (
+		art     Article
+		related []ArticleFeed
+	)
+	resp, err := clientRequest(req, "GET", "/article/"+id, token.Value, nil)
+	if err == nil {
+		err = json.NewDecoder(resp.Body).Decode(&art)
+		resp.Body.Close()
+	}
+	if err != nil || art.Id == "" {
+		log.Println("article: ", err)
+		http.Redirect(w, req, "/feed/0", 302)
+		return
+	}
+	err = serverJSON("GET", "/article/"+id+"/related", token.Value, &related)
+	if err != nil {
+		log.Println("related: ", err)
+	}
+
+	t := template.Must(template.ParseFiles(
+		"./templates/article.html",
+		"./templates/highlights.html",
+		"./templates/comments.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	var highlights []Highlight
+	err = serverJSON("GET", "/highlights?article="+url.QueryEscape(id), token.Value, &highlights)
+	if err != nil {
+		log.Println("highlights: ", err)
+	}
+
+	var own []Collection
+	err = serverJSON("GET", "/collections", token.Value, &own)
+	if err != nil {
+		log.Println("collections: ", err)
+	}
+
+	var teams []Workspace
+	err = serverJSON("GET", "/workspaces", token.Value, &teams)
+	if err != nil {
+		log.Println("workspaces: ", err)
+	}
+
+	var comments []*Comment
+	err = serverJSON("GET", "/article/"+id+"/comments", token.Value, &comments)
+	if err != nil {
+		log.Println("comments: ", err)
+	}
+
+	var user UserPublic
+	err = serverJSON("GET", "/account", token.Value, &user)
+	if err != nil {
+		log.Println("account: ", err)
+		// without the settings nothing is reported
+		user.NoReadTracking = true
+	}
+	data := struct {
+		Title       string
+		Auth        bool
+		L           int
+		D           int
+		Art         Article
+		Paragraphs  []string
+		Related     []ArticleFeed
+		Track       bool
+		Highlights  []Highlight
+		Collections []Collection
+		Workspaces  []Workspace
+		Shared      string
+		Comments    []*Comment
+	}{
+		art.Title,
+		true,
+		len(user.LikeNews),
+		len(user.DislikeNews),
+		art,
+		paragraphs(art.Text),
+		related,
+		!user.NoReadTracking,
+		highlights,
+		own,
+		teams,
+		req.URL.Query().Get("shared"),
+		comments,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func paragraphs(text string) []string {
+	var p []string
+	for _, s := range strings.Split(text, "\n") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			p = append(p, s)
+		}
+	}
+	return p
+}
+
+// articleRead passes dwell time and scroll depth of the reader view on
+func articleRead(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	form := url.Values{}
+	form.Set("dwell", req.FormValue("dwell"))
+	form.Set("scroll", req.FormValue("scroll"))
+	resp, err := clientRequest(req, "POST", "/article/"+mux.Vars(req)["id"]+"/read", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+	w.WriteHeader(resp.StatusCode)
+}
+
+// goLink sends the browser to the article through the server, which
+// counts the click and answers with a redirect to the stored link
+func goLink(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	r, err := http.NewRequest("GET", "http://server:12345/go/"+mux.Vars(req)["id"], nil)
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, req, "/feed/0", 302)
+		return
+	}
+	r.Header.Add("auth", token.Value)
+	forwardClient(r, req)
+	c := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := c.Do(r)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		http.Redirect(w, req, "/feed/0", 302)
+		return
+	}
+	resp.Body.Close()
+	link, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+		http.Redirect(w, req, "/feed/0", 302)
+		return
+	}
+	http.Redirect(w, req, link.String(), http.StatusFound)
+}
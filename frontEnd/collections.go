@@ -0,0 +1,284 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type CollectionItem struct {
+	Article bson.ObjectId
+}
+
+type Collection struct {
+	Id          bson.ObjectId
+	Name        string
+	Description string
+	Visibility  string
+	ShareToken  string
+	Items       []CollectionItem
+}
+
+type CollectionView struct {
+	Id          bson.ObjectId
+	Name        string
+	Description string
+	Visibility  string
+	ShareToken  string
+	Owner       bool
+	Articles    []ArticleFeed
+}
+
+type PublicCollection struct {
+	Name        string
+	Description string
+	ShareToken  string
+	Articles    int
+}
+
+func collections(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var (
+		own    []Collection
+		public []PublicCollection
+	)
+	err = serverJSON("GET", "/collections", token.Value, &own)
+	if err != nil {
+		log.Println("collections: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	err = serverJSON("GET", "/collections/public", "", &public)
+	if err != nil {
+		log.Println("public collections: ", err)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/collections.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title       string
+		Auth        bool
+		L           int
+		D           int
+		Collections []Collection
+		Public      []PublicCollection
+	}{
+		"Коллекции",
+		true,
+		l,
+		d,
+		own,
+		public,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+// renderCollection shows a collection to its owner or, read-only, to
+// anyone with the share link
+func renderCollection(w http.ResponseWriter, req *http.Request, token string, c CollectionView) {
+	t := template.Must(template.ParseFiles(
+		"./templates/collection.html",
+		"./templates/collections.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	var l, d int
+	if token != "" {
+		l, d = rateData(token)
+	}
+	data := struct {
+		Title      string
+		Auth       bool
+		L          int
+		D          int
+		Collection CollectionView
+		ShareURL   string
+	}{
+		c.Name,
+		token != "",
+		l,
+		d,
+		c,
+		siteURL(req, "/c/"+c.ShareToken),
+	}
+	err := t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func collectionPage(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var c CollectionView
+	err = serverJSON("GET", "/collections/"+mux.Vars(req)["id"], token.Value, &c)
+	if err != nil || c.Id == "" {
+		log.Println("collection: ", err)
+		http.Redirect(w, req, "/collections", 302)
+		return
+	}
+	renderCollection(w, req, token.Value, c)
+}
+
+func sharedCollection(w http.ResponseWriter, req *http.Request) {
+	var c CollectionView
+	err := serverJSON("GET", "/collections/shared/"+url.PathEscape(mux.Vars(req)["token"]), "", &c)
+	if err != nil || c.Id == "" {
+		http.NotFound(w, req)
+		return
+	}
+	token := ""
+	if t, err := req.Cookie("auth"); err == nil {
+		token = t.Value
+	}
+	renderCollection(w, req, token, c)
+}
+
+func collectionAdd(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	form := url.Values{}
+	for _, k := range []string{"name", "description", "visibility"} {
+		form.Set(k, req.FormValue(k))
+	}
+	resp, err := serverRequest("POST", "/collections", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/collections", 302)
+}
+
+func collectionEdit(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	id := mux.Vars(req)["id"]
+	form := url.Values{}
+	for _, k := range []string{"name", "description", "visibility"} {
+		form.Set(k, req.FormValue(k))
+	}
+	resp, err := serverRequest("POST", "/collections/"+id, token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/collections/view/"+url.PathEscape(id), 302)
+}
+
+func collectionDelete(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("DELETE", "/collections/"+mux.Vars(req)["id"], token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/collections", 302)
+}
+
+// collectionArticleAdd adds the article of the form to a collection
+// and returns to the article
+func collectionArticleAdd(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	form := url.Values{}
+	form.Set("article", req.FormValue("article"))
+	resp, err := serverRequest("POST", "/collections/"+url.PathEscape(req.FormValue("collection"))+"/articles", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	redirectBack(w, req, "/collections")
+}
+
+func collectionArticleRemove(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	vars := mux.Vars(req)
+	resp, err := serverRequest("DELETE", "/collections/"+vars["id"]+"/articles/"+vars["article"], token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/collections/view/"+url.PathEscape(vars["id"]), 302)
+}
+
+// collectionArticleMove moves an article one place up or down
+func collectionArticleMove(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	vars := mux.Vars(req)
+	back := "/collections/view/" + url.PathEscape(vars["id"])
+	var c CollectionView
+	err = serverJSON("GET", "/collections/"+vars["id"], token.Value, &c)
+	if err != nil {
+		log.Println("collection: ", err)
+		http.Redirect(w, req, back, 302)
+		return
+	}
+	ids := make([]string, len(c.Articles))
+	for i, a := range c.Articles {
+		ids[i] = a.Id.Hex()
+	}
+	for i, id := range ids {
+		if id != vars["article"] {
+			continue
+		}
+		j := i - 1
+		if req.FormValue("dir") == "down" {
+			j = i + 1
+		}
+		if j >= 0 && j < len(ids) {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+		break
+	}
+	form := url.Values{"article": ids}
+	resp, err := serverRequest("POST", "/collections/"+vars["id"]+"/order", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, back, 302)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Comment struct {
+	Id        bson.ObjectId
+	Article   bson.ObjectId
+	Parent    bson.ObjectId
+	Workspace bson.ObjectId
+	Author    string
+	Body      string
+	HTML      string
+	Created   time.Time
+	Edited    time.Time
+	Deleted   bool
+	Hidden    bool
+	Team      string
+	Mine      bool
+	Replies   []*Comment
+}
+
+// Rendered is the comment Markdown, sanitized by the server
+func (c Comment) Rendered() template.HTML {
+	return template.HTML(c.HTML)
+}
+
+type CommentReport struct {
+	Email   string
+	Reason  string
+	Created time.Time
+}
+
+type ModerationItem struct {
+	Comment
+	Title   string
+	Reasons []CommentReport
+}
+
+// commentsBack is the comments part of the article page of the form
+func commentsBack(req *http.Request) string {
+	article := req.FormValue("article")
+	if !bson.IsObjectIdHex(article) {
+		return "/feed/0"
+	}
+	return "/article/" + article + "#comments"
+}
+
+func commentAdd(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/article/"+mux.Vars(req)["id"]+"/comments", []string{"body", "parent", "workspace"}, commentsBack(req))
+}
+
+func commentEdit(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/comments/"+mux.Vars(req)["id"], []string{"body"}, commentsBack(req))
+}
+
+func commentRemove(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "DELETE", "/comments/"+mux.Vars(req)["id"], nil, commentsBack(req))
+}
+
+func commentReport(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/comments/"+mux.Vars(req)["id"]+"/report", []string{"reason"}, commentsBack(req))
+}
+
+// moderation is the queue of reported comments
+func moderation(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var queue []ModerationItem
+	err = serverJSON("GET", "/moderation/comments", token.Value, &queue)
+	if err != nil {
+		log.Println("moderation: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/moderation.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title string
+		Auth  bool
+		L     int
+		D     int
+		Queue []ModerationItem
+	}{
+		"Модерация",
+		true,
+		l,
+		d,
+		queue,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func moderationDecide(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/moderation/comments/"+mux.Vars(req)["id"], []string{"action"}, "/moderation")
+}
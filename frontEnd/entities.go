@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Keyword struct {
+	Word  string
+	Score float64
+}
+
+type Entity struct {
+	Key   string
+	Name  string
+	Kind  string
+	Count int
+}
+
+// KindName is the kind of the entity in Russian
+func (e Entity) KindName() string {
+	switch e.Kind {
+	case "person":
+		return "Персона"
+	case "org":
+		return "Организация"
+	case "place":
+		return "Место"
+	}
+	return e.Kind
+}
+
+type EntityView struct {
+	Entity
+	Articles  int
+	Following bool
+	Search    bson.ObjectId
+}
+
+type EntitiesPage struct {
+	Following []EntityView
+	Popular   []EntityView
+}
+
+func entities(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var p EntitiesPage
+	err = serverJSON("GET", "/entities", token.Value, &p)
+	if err != nil {
+		log.Println("entities: ", err)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/entities.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title    string
+		Auth     bool
+		L        int
+		D        int
+		Entities EntitiesPage
+	}{
+		"Упоминания",
+		true,
+		l,
+		d,
+		p,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+// entityPage lists the articles naming the entity
+func entityPage(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	vars := mux.Vars(req)
+	key := url.PathEscape(vars["key"])
+	var e EntityView
+	err = serverJSON("GET", "/entities/"+key, token.Value, &e)
+	if err != nil || e.Key == "" {
+		log.Println("entity: ", err)
+		http.Redirect(w, req, "/entities", 302)
+		return
+	}
+	if vars["page"] == "" {
+		vars["page"] = "0"
+	}
+	showMuted := req.URL.Query().Get("muted") == "1"
+	path := "/entities/" + key + "/feed/" + vars["page"]
+	if showMuted {
+		path += "?muted=1"
+	}
+	resp, err := serverRequest("GET", path, token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		http.Redirect(w, req, "/entities", 302)
+		return
+	}
+	defer resp.Body.Close()
+	ar, _ := ioutil.ReadAll(resp.Body)
+
+	var articles []ArticleFeed
+	err = json.Unmarshal(ar, &articles)
+	if err != nil {
+		log.Printf("json unmarshal %v\n", err)
+		http.Redirect(w, req, "/entities", 302)
+		return
+	}
+	page, _ := strconv.Atoi(vars["page"])
+	lastPage, _ := strconv.Atoi(resp.Header.Get("npage"))
+
+	t := template.Must(template.ParseFiles(
+		"./templates/entity.html",
+		"./templates/header.html",
+		"./templates/card.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Art       []ArticleFeed
+		Entity    EntityView
+		Prev      int
+		Next      int
+		LastPage  int
+		Title     string
+		Auth      bool
+		L         int
+		D         int
+		ShowMuted bool
+	}{
+		articles,
+		e,
+		page - 1,
+		page + 1,
+		lastPage,
+		e.Name,
+		true,
+		l,
+		d,
+		showMuted,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+// entityFollowToggle follows the entity or, with remove set, unfollows it
+func entityFollowToggle(w http.ResponseWriter, req *http.Request) {
+	method := "POST"
+	if req.FormValue("remove") == "1" {
+		method = "DELETE"
+	}
+	formAction(w, req, method, "/entities/"+url.PathEscape(mux.Vars(req)["key"])+"/follow", nil, "")
+}
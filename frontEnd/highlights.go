@@ -0,0 +1,163 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type QuoteSelector struct {
+	Exact  string
+	Prefix string
+	Suffix string
+	Start  int
+}
+
+type Highlight struct {
+	Id       bson.ObjectId
+	Article  bson.ObjectId
+	Selector QuoteSelector
+	Note     string
+	Created  time.Time
+	Title    string
+	Link     string
+	Orphaned bool
+}
+
+// HighlightGroup is the highlights of one article
+type HighlightGroup struct {
+	Article    bson.ObjectId
+	Title      string
+	Highlights []Highlight
+}
+
+func highlightsPage(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var list []Highlight
+	err = serverJSON("GET", "/highlights", token.Value, &list)
+	if err != nil {
+		log.Println("highlights: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	var groups []HighlightGroup
+	index := make(map[bson.ObjectId]int)
+	for _, h := range list {
+		i, ok := index[h.Article]
+		if !ok {
+			i = len(groups)
+			index[h.Article] = i
+			groups = append(groups, HighlightGroup{Article: h.Article, Title: h.Title})
+		}
+		groups[i].Highlights = append(groups[i].Highlights, h)
+	}
+
+	t := template.Must(template.ParseFiles(
+		"./templates/highlights.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title  string
+		Auth   bool
+		L      int
+		D      int
+		Groups []HighlightGroup
+	}{
+		"Мои выделения",
+		true,
+		l,
+		d,
+		groups,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func highlightAdd(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	req.ParseForm()
+	form := url.Values{}
+	for _, k := range []string{"article", "exact", "prefix", "suffix", "start", "note"} {
+		form.Set(k, req.FormValue(k))
+	}
+	resp, err := serverRequest("POST", "/highlights", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	redirectBack(w, req, "/highlights")
+}
+
+func highlightNote(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	form := url.Values{}
+	form.Set("note", req.FormValue("note"))
+	resp, err := serverRequest("POST", "/highlights/"+mux.Vars(req)["id"], token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	redirectBack(w, req, "/highlights")
+}
+
+func highlightDelete(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("DELETE", "/highlights/"+mux.Vars(req)["id"], token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	redirectBack(w, req, "/highlights")
+}
+
+// highlightsExport passes the Markdown file of the server through
+func highlightsExport(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("GET", "/highlights/export", token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		http.Redirect(w, req, "/highlights", 302)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		http.Redirect(w, req, "/highlights", 302)
+		return
+	}
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Content-Disposition", resp.Header.Get("Content-Disposition"))
+	io.Copy(w, resp.Body)
+}
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -20,20 +23,20 @@ type Tag struct {
 	Name  string
 	Value string
 }
-type TagJSON struct {
-	Name string `json:"Name"`
-}
-type Tags struct {
-	Tags []Tag
-}
 
 type Article struct {
 	Id        bson.ObjectId `bson:"_id,omitempty"`
 	Title     string        `bson:"title"`
 	Link      string        `bson:"link"`
+	TopImage  string        `bson:"topImage"`
 	Source    string        `bson:"source"`
+	Tags      []string      `bson:"tags"`
 	Text      string        `bson:"text"`
 	Timestamp time.Time     `bson:"timestamp"`
+	AutoTags  []AutoTag
+	Keywords  []Keyword
+	Entities  []Entity
+	Stats     *ArticleStats
 }
 
 type ArticleFeed struct {
@@ -42,33 +45,75 @@ type ArticleFeed struct {
 	Checked   bool
 	Link      string    `bson:"link"`
 	Source    string    `bson:"source"`
-	Text      string    `bson:"text"`
+	Summary   string    `bson:"summary"`
 	Timestamp time.Time `bson:"timestamp"`
+	Muted     *MuteRule
+	Reasons   []Reason
+	Pinned    bool
+	Snoozed   bool
+	Team      []TeamRating
+	Comments  int
+	AutoTags  []AutoTag
+}
+
+// AutoTag is a tag assigned by the classifier with its confidence
+type AutoTag struct {
+	Tag        string
+	Confidence float64
+}
+
+// Percent is the confidence in percent
+func (t AutoTag) Percent() int {
+	return int(t.Confidence*100 + 0.5)
+}
+
+// Reason explains why an article is in the feed, Kind is one of tag,
+// source, search, similar, trending, discovery, shared and followed
+type Reason struct {
+	Kind    string
+	Value   string
+	Article bson.ObjectId
+}
+
+type MuteRule struct {
+	Id    bson.ObjectId `bson:"_id"`
+	Kind  string        `bson:"kind"`
+	Value string        `bson:"value"`
 }
 
 type UserPublic struct {
-	Id          bson.ObjectId   `bson:"_id,omitempty"`
-	Email       string          `bson:"email"`
-	Tags        []string        `bson:"tags"`
-	Feed        []bson.ObjectId `bson:"feed"`
-	LikeNews    []bson.ObjectId `bson:"likeNews"`
-	DislikeNews []bson.ObjectId `bson:"dislikeNews"`
+	Id             bson.ObjectId   `bson:"_id,omitempty"`
+	Email          string          `bson:"email"`
+	Tags           []string        `bson:"tags"`
+	Feed           []bson.ObjectId `bson:"feed"`
+	LikeNews       []bson.ObjectId `bson:"likeNews"`
+	DislikeNews    []bson.ObjectId `bson:"dislikeNews"`
+	Mutes          []MuteRule      `bson:"mutes"`
+	Digest         DigestSettings  `bson:"digest"`
+	Admin          bool            `bson:"admin"`
+	Moderator      bool            `bson:"moderator"`
+	PublicProfile  bool            `bson:"publicProfile"`
+	ProfileName    string          `bson:"profileName"`
+	NoReadTracking bool            `bson:"noReadTracking"`
+	Diversity      DiversitySettings
 }
 
-var (
-	tags []TagJSON
-	T    Tags
-)
+// DiversitySettings of a user, zero values mean the server default and
+// -1 turns the source cap or the duplicate check off
+type DiversitySettings struct {
+	MaxRun       int
+	Interleave   string
+	DupThreshold float64
+}
+
+type DigestSettings struct {
+	Enabled   bool
+	Frequency string
+	Hour      int
+	TimeZone  string
+}
 
 func main() {
-	file, err := ioutil.ReadFile("./tags.json")
-	if err != nil {
-		log.Fatal(err)
-	}
-	json.Unmarshal(file, &tags)
-	for _, i := range tags {
-		T.Tags = append(T.Tags, Tag{i.Name, i.Name})
-	}
 	router := mux.NewRouter()
 	router.HandleFunc("/", mainPage)
 	router.HandleFunc("/auth", auth)
@@ -78,8 +123,85 @@ func main() {
 	router.HandleFunc("/account", account)
 	router.HandleFunc("/feed/{page:[0-9]+}", feed)
 	router.HandleFunc("/todayfeed", toDayFeed)
+	router.HandleFunc("/trending", trending)
+	router.HandleFunc("/stream", stream)
+	router.HandleFunc("/article/{id}", articlePage)
+	router.HandleFunc("/go/{id}", goLink)
+	router.HandleFunc("/article/{id}/read", articleRead).Methods("POST")
+	router.HandleFunc("/bookmark/{id}", bookmark)
+	router.HandleFunc("/bookmarks", bookmarks)
+	router.HandleFunc("/pin/{id}", pin)
+	router.HandleFunc("/snooze/{id}", snooze)
+	router.HandleFunc("/highlights", highlightsPage)
+	router.HandleFunc("/highlights/add", highlightAdd).Methods("POST")
+	router.HandleFunc("/highlights/note/{id}", highlightNote).Methods("POST")
+	router.HandleFunc("/highlights/delete/{id}", highlightDelete)
+	router.HandleFunc("/highlights/export", highlightsExport)
+	router.HandleFunc("/collections", collections)
+	router.HandleFunc("/collections/add", collectionAdd).Methods("POST")
+	router.HandleFunc("/collections/articles/add", collectionArticleAdd).Methods("POST")
+	router.HandleFunc("/collections/view/{id}", collectionPage)
+	router.HandleFunc("/collections/edit/{id}", collectionEdit).Methods("POST")
+	router.HandleFunc("/collections/delete/{id}", collectionDelete).Methods("POST")
+	router.HandleFunc("/collections/{id}/remove/{article}", collectionArticleRemove).Methods("POST")
+	router.HandleFunc("/collections/{id}/move/{article}", collectionArticleMove).Methods("POST")
+	router.HandleFunc("/c/{token}", sharedCollection)
+	router.HandleFunc("/s/{token}", sharedArticle)
+	router.HandleFunc("/article/{id}/share", shareSend).Methods("POST")
+	router.HandleFunc("/article/{id}/link", shareLinkCreate).Methods("POST")
+	router.HandleFunc("/article/{id}/comments/add", commentAdd).Methods("POST")
+	router.HandleFunc("/article/{id}/autotags", autoTagVote).Methods("POST")
+	router.HandleFunc("/comments/{id}/edit", commentEdit).Methods("POST")
+	router.HandleFunc("/comments/{id}/remove", commentRemove).Methods("POST")
+	router.HandleFunc("/comments/{id}/report", commentReport).Methods("POST")
+	router.HandleFunc("/moderation", moderation)
+	router.HandleFunc("/moderation/{id}", moderationDecide).Methods("POST")
+	router.HandleFunc("/entities", entities)
+	router.HandleFunc("/entities/{key}", entityPage)
+	router.HandleFunc("/entities/{key}/{page:[0-9]+}", entityPage)
+	router.HandleFunc("/entities/{key}/follow", entityFollowToggle).Methods("POST")
+	router.HandleFunc("/people", people)
+	router.HandleFunc("/people/{id}", profilePage)
+	router.HandleFunc("/people/{id}/follow", followToggle).Methods("POST")
+	router.HandleFunc("/profile/save", profileSave).Methods("POST")
+	router.HandleFunc("/admin/tags", adminTags)
+	router.HandleFunc("/admin/tags/save", adminTagSave).Methods("POST")
+	router.HandleFunc("/admin/tags/{slug}/rename", adminTagRename).Methods("POST")
+	router.HandleFunc("/admin/tags/{slug}/merge", adminTagMerge).Methods("POST")
+	router.HandleFunc("/inbox", inbox)
+	router.HandleFunc("/inbox/{id}/remove", inboxRemove).Methods("POST")
+	router.HandleFunc("/links", links)
+	router.HandleFunc("/links/{token}/remove", linkRemove).Methods("POST")
+	router.HandleFunc("/workspaces", workspaces)
+	router.HandleFunc("/workspaces/add", workspaceAdd).Methods("POST")
+	router.HandleFunc("/workspaces/mustread/add", mustReadAdd).Methods("POST")
+	router.HandleFunc("/invitations/{token}/accept", invitationAccept).Methods("POST")
+	router.HandleFunc("/workspaces/{id}", workspacePage)
+	router.HandleFunc("/workspaces/{id}/feed/{page:[0-9]+}", workspaceFeed)
+	router.HandleFunc("/workspaces/{id}/edit", workspaceEdit).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/delete", workspaceDelete).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/invite", workspaceInvite).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/members/{user}/remove", memberRemove).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/members/{user}/role", memberRole).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/mustread/{article}/remove", mustReadRemove).Methods("POST")
+	router.HandleFunc("/admin/sources", adminSources)
 	router.HandleFunc("/ratelike/{id}", like)
 	router.HandleFunc("/ratedislike/{id}", dislike)
+	router.HandleFunc("/rateunrate/{id}", unrate)
+	router.HandleFunc("/mutes/add", muteAdd).Methods("POST")
+	router.HandleFunc("/mutes/delete/{id}", muteDelete).Methods("POST")
+	router.HandleFunc("/digest/save", digestSave).Methods("POST")
+	router.HandleFunc("/tracking/save", trackingSave).Methods("POST")
+	router.HandleFunc("/diversity/save", diversitySave).Methods("POST")
+	router.HandleFunc("/searches", searches)
+	router.HandleFunc("/searches/add", searchAdd).Methods("POST")
+	router.HandleFunc("/searches/delete/{id}", searchDelete).Methods("POST")
+	router.HandleFunc("/searches/{id}/{page:[0-9]+}", searchFeed)
+	router.HandleFunc("/notifications/read", notificationsRead).Methods("POST")
+	router.HandleFunc("/webhooks/add", webhookAdd).Methods("POST")
+	router.HandleFunc("/webhooks/delete/{id}", webhookDelete).Methods("POST")
+	router.HandleFunc("/webhooks/enable/{id}", webhookEnable).Methods("POST")
+	router.HandleFunc("/webhooks/{id}", webhookDeliveries)
 	log.Fatal(http.ListenAndServe(":8080", router))
 }
 func mainPage(w http.ResponseWriter, req *http.Request) {
@@ -124,6 +246,40 @@ func mainPage(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+var feedModes = []string{"latest", "top", "recommended", "discovery", "following"}
+
+// feedMode takes the mode and the period of top from the query and
+// remembers them in cookies, without the query the last ones are used
+func feedMode(w http.ResponseWriter, req *http.Request) (mode, period string) {
+	mode, period = "latest", "week"
+	if c, err := req.Cookie("feedmode"); err == nil {
+		mode = c.Value
+	}
+	if c, err := req.Cookie("feedperiod"); err == nil {
+		period = c.Value
+	}
+	if m := req.URL.Query().Get("mode"); m != "" {
+		mode = m
+	}
+	if p := req.URL.Query().Get("period"); p != "" {
+		period = p
+	}
+	valid := false
+	for _, m := range feedModes {
+		valid = valid || m == mode
+	}
+	if !valid {
+		mode = "latest"
+	}
+	if period != "day" && period != "week" && period != "month" {
+		period = "week"
+	}
+	expires := time.Now().Add(365 * 24 * time.Hour)
+	http.SetCookie(w, &http.Cookie{Name: "feedmode", Value: mode, Path: "/", Expires: expires})
+	http.SetCookie(w, &http.Cookie{Name: "feedperiod", Value: period, Path: "/", Expires: expires})
+	return mode, period
+}
+
 func like(w http.ResponseWriter, req *http.Request) {
 	token, err := req.Cookie("auth")
 	if err != nil {
@@ -140,6 +296,7 @@ func like(w http.ResponseWriter, req *http.Request) {
 	}
 	c := &http.Client{}
 	r.Header.Add("auth", token.Value)
+	forwardClient(r, req)
 	_, err = c.Do(r)
 	if err != nil {
 		log.Printf("http.Do() error: %v\n", err)
@@ -149,6 +306,10 @@ func like(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(200)
 }
 
+func unrate(w http.ResponseWriter, req *http.Request) {
+	proxyAction(w, req, "POST", "/rateunrate/"+mux.Vars(req)["id"], nil)
+}
+
 func dislike(w http.ResponseWriter, req *http.Request) {
 	token, err := req.Cookie("auth")
 	if err != nil {
@@ -165,6 +326,7 @@ func dislike(w http.ResponseWriter, req *http.Request) {
 	}
 	c := &http.Client{}
 	r.Header.Add("auth", token.Value)
+	forwardClient(r, req)
 	_, err = c.Do(r)
 	if err != nil {
 		log.Printf("http.Do() error: %v\n", err)
@@ -185,8 +347,15 @@ func feed(w http.ResponseWriter, req *http.Request) {
 	if token.Value == "" {
 		http.Redirect(w, req, "/", 302)
 	} else {
-		url := "http://server:12345/feed/" + page["page"]
-		r, err := http.NewRequest("GET", url, nil)
+		showMuted := req.URL.Query().Get("muted") == "1"
+		mode, period := feedMode(w, req)
+		q := url.Values{}
+		q.Set("mode", mode)
+		q.Set("period", period)
+		if showMuted {
+			q.Set("muted", "1")
+		}
+		r, err := http.NewRequest("GET", "http://server:12345/feed/"+page["page"]+"?"+q.Encode(), nil)
 		if err != nil {
 			log.Println(err)
 			http.Redirect(w, req, "/", 302)
@@ -224,6 +393,7 @@ func feed(w http.ResponseWriter, req *http.Request) {
 		t := template.Must(template.ParseFiles(
 			"./templates/feed.html",
 			"./templates/header.html",
+			"./templates/card.html",
 			"./templates/footer.html",
 		))
 
@@ -238,13 +408,16 @@ func feed(w http.ResponseWriter, req *http.Request) {
 		l, d := rateData(token.Value)
 
 		data := struct {
-			Art      []ArticleFeed
-			Pages    []int
-			LastPage int
-			Title    string
-			Auth     bool
-			L        int
-			D        int
+			Art       []ArticleFeed
+			Pages     []int
+			LastPage  int
+			Title     string
+			Auth      bool
+			L         int
+			D         int
+			ShowMuted bool
+			Mode      string
+			Period    string
 		}{
 			articles,
 			pages,
@@ -253,6 +426,9 @@ func feed(w http.ResponseWriter, req *http.Request) {
 			a,
 			l,
 			d,
+			showMuted,
+			mode,
+			period,
 		}
 		err = t.Execute(w, data)
 		if err != nil {
@@ -273,7 +449,11 @@ func toDayFeed(w http.ResponseWriter, req *http.Request) {
 	if token.Value == "" {
 		http.Redirect(w, req, "/", 302)
 	} else {
+		showMuted := req.URL.Query().Get("muted") == "1"
 		url := "http://server:12345/todayfeed"
+		if showMuted {
+			url += "?muted=1"
+		}
 		r, err := http.NewRequest("GET", url, nil)
 		if err != nil {
 			log.Println(err)
@@ -301,6 +481,7 @@ func toDayFeed(w http.ResponseWriter, req *http.Request) {
 		t := template.Must(template.ParseFiles(
 			"./templates/today.html",
 			"./templates/header.html",
+			"./templates/card.html",
 			"./templates/footer.html",
 		))
 
@@ -315,17 +496,19 @@ func toDayFeed(w http.ResponseWriter, req *http.Request) {
 		l, d := rateData(token.Value)
 
 		data := struct {
-			Art   []ArticleFeed
-			Title string
-			Auth  bool
-			L     int
-			D     int
+			Art       []ArticleFeed
+			Title     string
+			Auth      bool
+			L         int
+			D         int
+			ShowMuted bool
 		}{
 			articles,
 			"За сегодня",
 			a,
 			l,
 			d,
+			showMuted,
 		}
 		err = t.Execute(w, data)
 		if err != nil {
@@ -355,7 +538,7 @@ func auth(w http.ResponseWriter, req *http.Request) {
 		Auth  bool
 	}{
 		"Авторизация",
-		T.Tags,
+		tagList(),
 		a,
 	}
 	err = t.Execute(w, data)
@@ -416,7 +599,233 @@ func logout(w http.ResponseWriter, req *http.Request) {
 }
 
 func account(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("GET", "/account", token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	defer resp.Body.Close()
+	ar, _ := ioutil.ReadAll(resp.Body)
+
+	var user UserPublic
+	err = json.Unmarshal(ar, &user)
+	if err != nil {
+		log.Printf("json unmarshal %v\n", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+
+	t := template.Must(template.ParseFiles(
+		"./templates/account.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	var diversity struct {
+		Default DiversitySettings
+	}
+	err = serverJSON("GET", "/diversity", token.Value, &diversity)
+	if err != nil {
+		log.Println("diversity: ", err)
+	}
+	data := struct {
+		Title     string
+		Auth      bool
+		L         int
+		D         int
+		User      UserPublic
+		Diversity DiversitySettings
+	}{
+		"Настройки",
+		true,
+		len(user.LikeNews),
+		len(user.DislikeNews),
+		user,
+		diversity.Default,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
 
+func muteAdd(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	req.ParseForm()
+	form := url.Values{}
+	form.Set("kind", req.FormValue("kind"))
+	form.Set("value", req.FormValue("value"))
+	resp, err := serverRequest("POST", "/mutes", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	// muting from a feed card returns to the same page
+	if req.FormValue("back") == "1" {
+		redirectBack(w, req, "/account")
+		return
+	}
+	http.Redirect(w, req, "/account", 302)
+}
+
+// redirectBack returns to the page of the referer, only its path is
+// used so the redirect stays on this site. A path starting with // or
+// /\ would be taken by the browser as the address of another site.
+func redirectBack(w http.ResponseWriter, req *http.Request, def string) {
+	if ref, err := url.Parse(req.Referer()); err == nil && ref.Path != "" {
+		back := ref.RequestURI()
+		if strings.HasPrefix(back, "/") && !strings.HasPrefix(back, "//") && !strings.HasPrefix(back, "/\\") {
+			http.Redirect(w, req, back, 302)
+			return
+		}
+	}
+	http.Redirect(w, req, def, 302)
+}
+
+func muteDelete(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("DELETE", "/mutes/"+mux.Vars(req)["id"], token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/account", 302)
+}
+
+func diversitySave(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	form := url.Values{}
+	for _, k := range []string{"maxRun", "maxRunOff", "interleave", "dupThreshold", "dupOff"} {
+		form.Set(k, req.FormValue(k))
+	}
+	resp, err := serverRequest("POST", "/diversity", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/account", 302)
+}
+
+func trackingSave(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	form := url.Values{}
+	form.Set("enabled", req.FormValue("enabled"))
+	resp, err := serverRequest("POST", "/account/tracking", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/account", 302)
+}
+
+func digestSave(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	req.ParseForm()
+	form := url.Values{}
+	for _, k := range []string{"enabled", "frequency", "hour", "timezone"} {
+		form.Set(k, req.FormValue(k))
+	}
+	resp, err := serverRequest("POST", "/digest", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/account", 302)
+}
+
+// serverRequest calls the api server on behalf of the user
+func serverRequest(method, path, token string, form url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	r, err := http.NewRequest(method, "http://server:12345"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r.Header.Add("auth", token)
+	c := &http.Client{}
+	return c.Do(r)
+}
+
+// forwardClient passes the browser's address and user agent to the
+// server, which logs them with interactions
+func forwardClient(r *http.Request, req *http.Request) {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	r.Header.Set("X-Forwarded-For", ip)
+	r.Header.Set("User-Agent", req.UserAgent())
+	if ref := req.Referer(); ref != "" {
+		r.Header.Set("Referer", ref)
+	}
+}
+
+// clientRequest is serverRequest on behalf of the browser request req
+func clientRequest(req *http.Request, method, path, token string, form url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	r, err := http.NewRequest(method, "http://server:12345"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r.Header.Add("auth", token)
+	forwardClient(r, req)
+	c := &http.Client{}
+	return c.Do(r)
+}
+
+// serverJSON calls the api server and decodes its json answer into v
+func serverJSON(method, path, token string, v interface{}) error {
+	resp, err := serverRequest(method, path, token, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ar, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(ar, v)
 }
 
 func rateData(token string) (like int, dislike int) {
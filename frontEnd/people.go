@@ -0,0 +1,112 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type ProfileCard struct {
+	Id        bson.ObjectId
+	Name      string
+	Followers int
+	Following bool
+}
+
+type Profile struct {
+	ProfileCard
+	Me    bool
+	Likes []ArticleFeed
+}
+
+func people(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var list []ProfileCard
+	err = serverJSON("GET", "/profiles", token.Value, &list)
+	if err != nil {
+		log.Println("profiles: ", err)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/people.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title    string
+		Auth     bool
+		L        int
+		D        int
+		Profiles []ProfileCard
+	}{
+		"Люди",
+		true,
+		l,
+		d,
+		list,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func profilePage(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var p Profile
+	err = serverJSON("GET", "/profiles/"+url.PathEscape(mux.Vars(req)["id"]), token.Value, &p)
+	if err != nil || p.Id == "" {
+		log.Println("profile: ", err)
+		http.Redirect(w, req, "/people", 302)
+		return
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/profile.html",
+		"./templates/card.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title   string
+		Auth    bool
+		L       int
+		D       int
+		Profile Profile
+	}{
+		p.Name,
+		true,
+		l,
+		d,
+		p,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+// followToggle follows the profile or, with remove set, unfollows it
+func followToggle(w http.ResponseWriter, req *http.Request) {
+	method := "POST"
+	if req.FormValue("remove") == "1" {
+		method = "DELETE"
+	}
+	formAction(w, req, method, "/profiles/"+mux.Vars(req)["id"]+"/follow", nil, "")
+}
+
+func profileSave(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/account/profile", []string{"public", "name"}, "/account")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+// pin pins an article to the top of the feed, ?remove=1 unpins it
+func pin(w http.ResponseWriter, req *http.Request) {
+	method := "POST"
+	if req.URL.Query().Get("remove") == "1" {
+		method = "DELETE"
+	}
+	proxyAction(w, req, method, "/pin/"+mux.Vars(req)["id"], nil)
+}
+
+// snooze hides an article until later, tomorrow or week, ?remove=1
+// brings it back
+func snooze(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("remove") == "1" {
+		proxyAction(w, req, "DELETE", "/snooze/"+mux.Vars(req)["id"], nil)
+		return
+	}
+	form := url.Values{}
+	form.Set("until", req.URL.Query().Get("until"))
+	proxyAction(w, req, "POST", "/snooze/"+mux.Vars(req)["id"], form)
+}
+
+// proxyAction passes an ajax action of a card to the server and
+// answers with the server's status
+func proxyAction(w http.ResponseWriter, req *http.Request, method, path string, form url.Values) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	resp, err := clientRequest(req, method, path, token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+	w.WriteHeader(resp.StatusCode)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Query struct {
+	Text    string
+	Sources []string
+	Tags    []string
+	// Entities are set when the search follows an entity
+	Entities []string
+}
+
+type SavedSearch struct {
+	Id      bson.ObjectId
+	Name    string
+	Query   Query
+	Notify  []string
+	Webhook string
+}
+
+type Notification struct {
+	Id        bson.ObjectId
+	Search    bson.ObjectId
+	Name      string
+	Article   bson.ObjectId
+	Title     string
+	Link      string
+	Read      bool
+	Timestamp time.Time
+}
+
+func searches(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var (
+		saved         []SavedSearch
+		notifications []Notification
+		hooks         []Webhook
+	)
+	err = serverJSON("GET", "/searches", token.Value, &saved)
+	if err != nil {
+		log.Println("searches: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	err = serverJSON("GET", "/notifications", token.Value, &notifications)
+	if err != nil {
+		log.Println("notifications: ", err)
+	}
+	err = serverJSON("GET", "/webhooks", token.Value, &hooks)
+	if err != nil {
+		log.Println("webhooks: ", err)
+	}
+
+	t := template.Must(template.ParseFiles(
+		"./templates/searches.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title         string
+		Auth          bool
+		L             int
+		D             int
+		Searches      []SavedSearch
+		Notifications []Notification
+		Webhooks      []Webhook
+		Tags          []Tag
+	}{
+		"Подписки",
+		true,
+		l,
+		d,
+		saved,
+		notifications,
+		hooks,
+		tagList(),
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func searchAdd(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	req.ParseForm()
+	form := url.Values{}
+	for _, k := range []string{"name", "q", "sources", "tags", "notify", "webhook"} {
+		form[k] = req.Form[k]
+	}
+	resp, err := serverRequest("POST", "/searches", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/searches", 302)
+}
+
+func searchDelete(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("DELETE", "/searches/"+mux.Vars(req)["id"], token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/searches", 302)
+}
+
+func searchFeed(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	vars := mux.Vars(req)
+	showMuted := req.URL.Query().Get("muted") == "1"
+	path := "/searches/" + vars["id"] + "/feed/" + vars["page"]
+	if showMuted {
+		path += "?muted=1"
+	}
+	resp, err := serverRequest("GET", path, token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	defer resp.Body.Close()
+	ar, _ := ioutil.ReadAll(resp.Body)
+
+	var articles []ArticleFeed
+	err = json.Unmarshal(ar, &articles)
+	if err != nil {
+		log.Printf("json unmarshal %v\n", err)
+		http.Redirect(w, req, "/searches", 302)
+		return
+	}
+	page, _ := strconv.Atoi(vars["page"])
+	lastPage, _ := strconv.Atoi(resp.Header.Get("npage"))
+
+	t := template.Must(template.ParseFiles(
+		"./templates/search.html",
+		"./templates/header.html",
+		"./templates/card.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Art       []ArticleFeed
+		Search    string
+		Prev      int
+		Next      int
+		LastPage  int
+		Title     string
+		Auth      bool
+		L         int
+		D         int
+		ShowMuted bool
+	}{
+		articles,
+		vars["id"],
+		page - 1,
+		page + 1,
+		lastPage,
+		"Подписка",
+		true,
+		l,
+		d,
+		showMuted,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func notificationsRead(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("POST", "/notifications/read", token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/searches", 302)
+}
+
+type Webhook struct {
+	Id       bson.ObjectId
+	URL      string
+	Secret   string
+	Filter   Query
+	Failures int
+	Disabled bool
+}
+
+type WebhookDelivery struct {
+	Article   bson.ObjectId
+	Attempt   int
+	Status    int
+	Error     string
+	Duration  time.Duration
+	Timestamp time.Time
+}
+
+func webhookAdd(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	req.ParseForm()
+	form := url.Values{}
+	for _, k := range []string{"url", "q", "sources", "tags"} {
+		form[k] = req.Form[k]
+	}
+	resp, err := serverRequest("POST", "/webhooks", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/searches", 302)
+}
+
+func webhookDelete(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("DELETE", "/webhooks/"+mux.Vars(req)["id"], token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/searches", 302)
+}
+
+func webhookEnable(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	resp, err := serverRequest("POST", "/webhooks/"+mux.Vars(req)["id"]+"/enable", token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/searches", 302)
+}
+
+func webhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var deliveries []WebhookDelivery
+	err = serverJSON("GET", "/webhooks/"+mux.Vars(req)["id"]+"/deliveries", token.Value, &deliveries)
+	if err != nil {
+		log.Println("webhook deliveries: ", err)
+		http.Redirect(w, req, "/searches", 302)
+		return
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/webhook.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title      string
+		Auth       bool
+		L          int
+		D          int
+		Deliveries []WebhookDelivery
+	}{
+		"Webhook",
+		true,
+		l,
+		d,
+		deliveries,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Share struct {
+	Id        bson.ObjectId
+	FromEmail string
+	Message   string
+	Read      bool
+	Created   time.Time
+	Article   ArticleFeed
+}
+
+type ShareLink struct {
+	Token    string
+	Article  bson.ObjectId
+	Title    string
+	Views    int
+	LastView time.Time
+	Created  time.Time
+	URL      string
+}
+
+type PublicArticle struct {
+	Title     string
+	Link      string
+	TopImage  string
+	Source    string
+	Text      string
+	Timestamp time.Time
+}
+
+// siteURL makes an absolute link to path of this site
+func siteURL(req *http.Request, path string) string {
+	u := url.URL{Scheme: "http", Host: req.Host, Path: path}
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// shareSend sends the article to another user and returns to the
+// article page with the result in the shared parameter
+func shareSend(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	id := mux.Vars(req)["id"]
+	form := url.Values{}
+	form.Set("email", req.FormValue("email"))
+	form.Set("message", req.FormValue("message"))
+	result := "0"
+	resp, err := clientRequest(req, "POST", "/article/"+id+"/share", token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		if resp.StatusCode == http.StatusOK {
+			result = "1"
+		}
+		resp.Body.Close()
+	}
+	http.Redirect(w, req, "/article/"+url.PathEscape(id)+"?shared="+result, 302)
+}
+
+func shareLinkCreate(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/article/"+mux.Vars(req)["id"]+"/link", nil, "/links")
+}
+
+func inbox(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var shares []Share
+	err = serverJSON("GET", "/inbox", token.Value, &shares)
+	if err != nil {
+		log.Println("inbox: ", err)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/inbox.html",
+		"./templates/card.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title  string
+		Auth   bool
+		L      int
+		D      int
+		Shares []Share
+	}{
+		"Входящие",
+		true,
+		l,
+		d,
+		shares,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func inboxRemove(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "DELETE", "/inbox/"+mux.Vars(req)["id"], nil, "/inbox")
+}
+
+// links lists the share links of the user with their view counts
+func links(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var list []ShareLink
+	err = serverJSON("GET", "/links", token.Value, &list)
+	if err != nil {
+		log.Println("links: ", err)
+	}
+	for i := range list {
+		list[i].URL = siteURL(req, "/s/"+list[i].Token)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/links.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title string
+		Auth  bool
+		L     int
+		D     int
+		Links []ShareLink
+	}{
+		"Ссылки",
+		true,
+		l,
+		d,
+		list,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func linkRemove(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "DELETE", "/links/"+url.PathEscape(mux.Vars(req)["token"]), nil, "/links")
+}
+
+// sharedArticle is the read-only article page of a share link, it is
+// open without login
+func sharedArticle(w http.ResponseWriter, req *http.Request) {
+	var art PublicArticle
+	err := serverJSON("GET", "/links/"+url.PathEscape(mux.Vars(req)["token"])+"/article", "", &art)
+	if err != nil || art.Title == "" {
+		http.NotFound(w, req)
+		return
+	}
+	token := ""
+	if t, err := req.Cookie("auth"); err == nil {
+		token = t.Value
+	}
+	var l, d int
+	if token != "" {
+		l, d = rateData(token)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/shared.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	data := struct {
+		Title      string
+		Auth       bool
+		L          int
+		D          int
+		Art        PublicArticle
+		Paragraphs []string
+	}{
+		art.Title,
+		token != "",
+		l,
+		d,
+		art,
+		paragraphs(art.Text),
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
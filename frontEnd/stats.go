@@ -0,0 +1,117 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type ArticleStats struct {
+	Likes     int
+	Dislikes  int
+	Opens     int
+	Bookmarks int
+}
+
+type SourceStats struct {
+	Source    string
+	Articles  int
+	Likes     int
+	Dislikes  int
+	Opens     int
+	Bookmarks int
+	LikeRatio float64
+	Poor      bool
+}
+
+func bookmark(w http.ResponseWriter, req *http.Request) {
+	method := "POST"
+	if req.URL.Query().Get("remove") == "1" {
+		method = "DELETE"
+	}
+	proxyAction(w, req, method, "/bookmark/"+mux.Vars(req)["id"], nil)
+}
+
+func bookmarks(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var articles []ArticleFeed
+	err = serverJSON("GET", "/bookmarks", token.Value, &articles)
+	if err != nil {
+		log.Println("bookmarks: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	renderList(w, token.Value, "Закладки", articles)
+}
+
+// renderList shows articles as feed cards without pagination
+func renderList(w http.ResponseWriter, token, title string, articles []ArticleFeed) {
+	t := template.Must(template.ParseFiles(
+		"./templates/list.html",
+		"./templates/header.html",
+		"./templates/card.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token)
+	data := struct {
+		Art   []ArticleFeed
+		Title string
+		Auth  bool
+		L     int
+		D     int
+	}{
+		articles,
+		title,
+		true,
+		l,
+		d,
+	}
+	err := t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func adminSources(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var sources []SourceStats
+	err = serverJSON("GET", "/admin/sources", token.Value, &sources)
+	if err != nil {
+		log.Println("admin sources: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/sources.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title   string
+		Auth    bool
+		L       int
+		D       int
+		Sources []SourceStats
+	}{
+		"Источники",
+		true,
+		l,
+		d,
+		sources,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TagView is a tag of the server taxonomy
+type TagView struct {
+	Slug         string
+	Name         string
+	Aliases      []string
+	Translations map[string]string
+	Parent       string
+	Children     []string
+}
+
+const tagsTTL = 5 * time.Minute
+
+var tagCache struct {
+	sync.Mutex
+	tags   []TagView
+	loaded time.Time
+}
+
+// tagViews returns the taxonomy of the server, it is cached for tagsTTL
+// and the old copy is kept while the server is unavailable
+func tagViews() []TagView {
+	tagCache.Lock()
+	defer tagCache.Unlock()
+	if time.Since(tagCache.loaded) < tagsTTL {
+		return tagCache.tags
+	}
+	var tags []TagView
+	err := serverJSON("GET", "/tags", "", &tags)
+	if err != nil {
+		log.Println("tags: ", err)
+		return tagCache.tags
+	}
+	tagCache.tags, tagCache.loaded = tags, time.Now()
+	return tags
+}
+
+// tagList returns the tags for checkboxes, top level tags first and
+// each followed by its children
+func tagList() []Tag {
+	views := tagViews()
+	bySlug := make(map[string]TagView)
+	for _, t := range views {
+		bySlug[t.Slug] = t
+	}
+	var list []Tag
+	var add func(t TagView, depth int)
+	add = func(t TagView, depth int) {
+		list = append(list, Tag{t.Name, t.Slug})
+		for _, c := range t.Children {
+			if child, ok := bySlug[c]; ok && depth < 10 {
+				add(child, depth+1)
+			}
+		}
+	}
+	for _, t := range views {
+		if _, ok := bySlug[t.Parent]; !ok {
+			add(t, 0)
+		}
+	}
+	return list
+}
+
+// resetTags makes the next tagViews call read the server
+func resetTags() {
+	tagCache.Lock()
+	tagCache.loaded = time.Time{}
+	tagCache.Unlock()
+}
+
+func adminTags(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var user UserPublic
+	err = serverJSON("GET", "/account", token.Value, &user)
+	if err != nil || !user.Admin {
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	resetTags()
+	t := template.Must(template.ParseFiles(
+		"./templates/tags.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	data := struct {
+		Title string
+		Auth  bool
+		L     int
+		D     int
+		Tags  []TagView
+	}{
+		"Теги",
+		true,
+		len(user.LikeNews),
+		len(user.DislikeNews),
+		tagViews(),
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func adminTagSave(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/admin/tags", []string{"slug", "name", "parent", "aliases", "translations"}, "/admin/tags")
+}
+
+func adminTagRename(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/admin/tags/"+mux.Vars(req)["slug"]+"/rename", []string{"to"}, "/admin/tags")
+}
+
+func adminTagMerge(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/admin/tags/"+mux.Vars(req)["slug"]+"/merge", []string{"into"}, "/admin/tags")
+}
+
+// autoTagVote tells the server whether an auto tag fits the article
+func autoTagVote(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	formAction(w, req, "POST", "/article/"+id+"/autotags", []string{"tag", "correct"}, "/article/"+url.PathEscape(id))
+}
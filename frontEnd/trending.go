@@ -0,0 +1,53 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+func trending(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	tag := req.URL.Query().Get("tag")
+	var articles []ArticleFeed
+	err = serverJSON("GET", "/trending?tag="+url.QueryEscape(tag), token.Value, &articles)
+	if err != nil {
+		log.Println("trending: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+
+	t := template.Must(template.ParseFiles(
+		"./templates/trending.html",
+		"./templates/header.html",
+		"./templates/card.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Art   []ArticleFeed
+		Tags  []Tag
+		Tag   string
+		Title string
+		Auth  bool
+		L     int
+		D     int
+	}{
+		articles,
+		tagList(),
+		tag,
+		"Популярное",
+		true,
+		l,
+		d,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Member struct {
+	User   bson.ObjectId
+	Email  string
+	Role   string
+	Joined time.Time
+}
+
+type TeamRating struct {
+	Email  string
+	Rating string
+}
+
+type MustReadView struct {
+	By      string
+	Note    string
+	Added   time.Time
+	Article ArticleFeed
+}
+
+type Workspace struct {
+	Id       bson.ObjectId
+	Name     string
+	Tags     []string
+	Sources  []string
+	Members  []Member
+	Me       bson.ObjectId
+	Role     string
+	MustRead []MustReadView
+}
+
+type Invitation struct {
+	Token string
+	Name  string
+	By    string
+	Role  string
+}
+
+// TagChoice is a tag checkbox of a settings form
+type TagChoice struct {
+	Tag
+	Checked bool
+}
+
+// formAction passes the form fields keys to the server and returns to
+// back, an empty back returns to the referer
+func formAction(w http.ResponseWriter, req *http.Request, method, path string, keys []string, back string) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	req.ParseForm()
+	var form url.Values
+	if len(keys) > 0 {
+		form = url.Values{}
+		for _, k := range keys {
+			form[k] = req.Form[k]
+		}
+	}
+	resp, err := serverRequest(method, path, token.Value, form)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+	} else {
+		resp.Body.Close()
+	}
+	if back == "" {
+		redirectBack(w, req, "/workspaces")
+		return
+	}
+	http.Redirect(w, req, back, 302)
+}
+
+func workspaces(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var (
+		list        []Workspace
+		invitations []Invitation
+	)
+	err = serverJSON("GET", "/workspaces", token.Value, &list)
+	if err != nil {
+		log.Println("workspaces: ", err)
+		http.Redirect(w, req, "/", 302)
+		return
+	}
+	err = serverJSON("GET", "/invitations", token.Value, &invitations)
+	if err != nil {
+		log.Println("invitations: ", err)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/workspaces.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title       string
+		Auth        bool
+		L           int
+		D           int
+		Workspaces  []Workspace
+		Invitations []Invitation
+	}{
+		"Команды",
+		true,
+		l,
+		d,
+		list,
+		invitations,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func workspacePage(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	var ws Workspace
+	err = serverJSON("GET", "/workspaces/"+mux.Vars(req)["id"], token.Value, &ws)
+	if err != nil || ws.Id == "" {
+		log.Println("workspace: ", err)
+		http.Redirect(w, req, "/workspaces", 302)
+		return
+	}
+	var tags []TagChoice
+	for _, t := range tagList() {
+		c := TagChoice{Tag: t}
+		for _, wt := range ws.Tags {
+			c.Checked = c.Checked || wt == t.Value
+		}
+		tags = append(tags, c)
+	}
+	t := template.Must(template.ParseFiles(
+		"./templates/workspace.html",
+		"./templates/header.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title     string
+		Auth      bool
+		L         int
+		D         int
+		Workspace Workspace
+		Tags      []TagChoice
+		Admin     bool
+		Owner     bool
+	}{
+		ws.Name,
+		true,
+		l,
+		d,
+		ws,
+		tags,
+		ws.Role == "owner" || ws.Role == "admin",
+		ws.Role == "owner",
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func workspaceFeed(w http.ResponseWriter, req *http.Request) {
+	token, err := req.Cookie("auth")
+	if err != nil || token.Value == "" {
+		http.Redirect(w, req, "/auth", 302)
+		return
+	}
+	vars := mux.Vars(req)
+	back := "/workspaces/" + url.PathEscape(vars["id"])
+	var ws Workspace
+	err = serverJSON("GET", "/workspaces/"+vars["id"], token.Value, &ws)
+	if err != nil || ws.Id == "" {
+		log.Println("workspace: ", err)
+		http.Redirect(w, req, "/workspaces", 302)
+		return
+	}
+	resp, err := serverRequest("GET", "/workspaces/"+vars["id"]+"/feed/"+vars["page"], token.Value, nil)
+	if err != nil {
+		log.Printf("http.Do() error: %v\n", err)
+		http.Redirect(w, req, back, 302)
+		return
+	}
+	defer resp.Body.Close()
+	ar, _ := ioutil.ReadAll(resp.Body)
+	var articles []ArticleFeed
+	err = json.Unmarshal(ar, &articles)
+	if err != nil {
+		log.Printf("json unmarshal %v\n", err)
+		http.Redirect(w, req, back, 302)
+		return
+	}
+	nPages, _ := strconv.Atoi(resp.Header.Get("npage"))
+	var pages []int
+	for i := 0; i <= nPages; i++ {
+		pages = append(pages, i)
+	}
+
+	t := template.Must(template.ParseFiles(
+		"./templates/workspacefeed.html",
+		"./templates/header.html",
+		"./templates/card.html",
+		"./templates/footer.html",
+	))
+	l, d := rateData(token.Value)
+	data := struct {
+		Title     string
+		Auth      bool
+		L         int
+		D         int
+		Workspace Workspace
+		Art       []ArticleFeed
+		Pages     []int
+	}{
+		ws.Name,
+		true,
+		l,
+		d,
+		ws,
+		articles,
+		pages,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("template %v\n", err)
+	}
+}
+
+func workspaceAdd(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/workspaces", []string{"name"}, "/workspaces")
+}
+
+func workspaceEdit(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	formAction(w, req, "POST", "/workspaces/"+id, []string{"name", "tags", "sources"}, "/workspaces/"+url.PathEscape(id))
+}
+
+func workspaceDelete(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "DELETE", "/workspaces/"+mux.Vars(req)["id"], nil, "/workspaces")
+}
+
+func workspaceInvite(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	formAction(w, req, "POST", "/workspaces/"+id+"/invitations", []string{"email", "role"}, "/workspaces/"+url.PathEscape(id))
+}
+
+func invitationAccept(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/invitations/"+url.PathEscape(mux.Vars(req)["token"])+"/accept", nil, "/workspaces")
+}
+
+func memberRemove(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	formAction(w, req, "DELETE", "/workspaces/"+vars["id"]+"/members/"+vars["user"], nil, "/workspaces")
+}
+
+func memberRole(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	formAction(w, req, "POST", "/workspaces/"+vars["id"]+"/members/"+vars["user"]+"/role", []string{"role"}, "/workspaces/"+url.PathEscape(vars["id"]))
+}
+
+// mustReadAdd is posted from the reader view with the workspace in the form
+func mustReadAdd(w http.ResponseWriter, req *http.Request) {
+	formAction(w, req, "POST", "/workspaces/"+url.PathEscape(req.FormValue("workspace"))+"/mustread", []string{"article", "note"}, "")
+}
+
+func mustReadRemove(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	formAction(w, req, "DELETE", "/workspaces/"+vars["id"]+"/mustread/"+vars["article"], nil, "/workspaces/"+url.PathEscape(vars["id"]))
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// visibility of a collection
+const (
+	visibilityPrivate = "private"
+	visibilityLink    = "link"
+	visibilityPublic  = "public"
+)
+
+const (
+	maxCollections     = 50
+	maxCollectionItems = 500
+)
+
+type CollectionItem struct {
+	Article bson.ObjectId `bson:"article"`
+	Added   time.Time     `bson:"added"`
+}
+
+// Collection is a named ordered list of articles. Link and public
+// collections are read by anyone with the share token, public ones
+// are listed as well.
+type Collection struct {
+	Id          bson.ObjectId    `bson:"_id"`
+	User        bson.ObjectId    `bson:"user"`
+	Name        string           `bson:"name"`
+	Description string           `bson:"description"`
+	Visibility  string           `bson:"visibility"`
+	ShareToken  string           `bson:"shareToken"`
+	Items       []CollectionItem `bson:"items"`
+	Created     time.Time        `bson:"created"`
+	Updated     time.Time        `bson:"updated"`
+}
+
+// CollectionView is a collection with its articles, Owner is set for
+// the owner only
+type CollectionView struct {
+	Id          bson.ObjectId
+	Name        string
+	Description string
+	Visibility  string
+	ShareToken  string
+	Owner       bool
+	Articles    []ArticleFeed
+}
+
+// PublicCollection is an entry of the list of public collections
+type PublicCollection struct {
+	Name        string
+	Description string
+	ShareToken  string
+	Articles    int
+}
+
+func validVisibility(v string) bool {
+	return v == visibilityPrivate || v == visibilityLink || v == visibilityPublic
+}
+
+// collectionForm reads and checks name, description and visibility
+func collectionForm(req *http.Request, c *Collection) string {
+	c.Name = strings.TrimSpace(req.FormValue("name"))
+	c.Description = strings.TrimSpace(req.FormValue("description"))
+	c.Visibility = req.FormValue("visibility")
+	if c.Visibility == "" {
+		c.Visibility = visibilityPrivate
+	}
+	if c.Name == "" || utf8.RuneCountInString(c.Name) > 100 {
+		return "Invalid name"
+	}
+	if utf8.RuneCountInString(c.Description) > 2000 {
+		return "Description is too long"
+	}
+	if !validVisibility(c.Visibility) {
+		return "Unknown visibility"
+	}
+	return ""
+}
+
+func collectionView(ds *DataStore, c Collection, checked []bson.ObjectId, owner bool) CollectionView {
+	v := CollectionView{
+		Id:          c.Id,
+		Name:        c.Name,
+		Description: c.Description,
+		Visibility:  c.Visibility,
+		Owner:       owner,
+		Articles:    []ArticleFeed{},
+	}
+	if owner {
+		v.ShareToken = c.ShareToken
+	}
+	ca := ds.C("Articles")
+	for _, i := range c.Items {
+		var a Article
+		if ca.FindId(i.Article).One(&a) == nil {
+			v.Articles = append(v.Articles, newArticleFeed(a, checked))
+		}
+	}
+	return v
+}
+
+// ownCollection finds the collection {id} of the user
+func ownCollection(ds *DataStore, req *http.Request) (User, Collection, string) {
+	var c Collection
+	user, err := currentUser(ds, req)
+	if err != nil {
+		return user, c, "Can't find user"
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		return user, c, "Invalid id"
+	}
+	err = ds.C("Collections").Find(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id}).One(&c)
+	if err != nil {
+		return user, c, "Can't find collection"
+	}
+	return user, c, ""
+}
+
+func collectionsList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	list := []Collection{}
+	err = ds.C("Collections").Find(bson.M{"user": user.Id}).Sort("-updated").All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find collections")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+func collectionAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	cc := ds.C("Collections")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	c := Collection{
+		Id:         bson.NewObjectId(),
+		User:       user.Id,
+		ShareToken: randomToken(),
+		Items:      []CollectionItem{},
+		Created:    time.Now(),
+	}
+	c.Updated = c.Created
+	if msg := collectionForm(req, &c); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	n, err := cc.Find(bson.M{"user": user.Id}).Count()
+	if err != nil || n >= maxCollections {
+		respondWithError(w, http.StatusBadRequest, "Too many collections")
+		return
+	}
+	err = cc.Insert(c)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save collection")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, c)
+}
+
+func collectionGet(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, c, msg := ownCollection(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	respondWithJSON(w, http.StatusOK, collectionView(ds, c, checked, true))
+}
+
+// collectionChange edits name, description and visibility, a new
+// share link is made when the collection turns private or stops being
+// listed in public, so the link seen in the directory stops working
+func collectionChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, c, msg := ownCollection(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	was := c.Visibility
+	if msg = collectionForm(req, &c); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	set := bson.M{"name": c.Name, "description": c.Description, "visibility": c.Visibility, "updated": time.Now()}
+	if (was != visibilityPrivate && c.Visibility == visibilityPrivate) ||
+		(was == visibilityPublic && c.Visibility == visibilityLink) {
+		set["shareToken"] = randomToken()
+	}
+	err := ds.C("Collections").UpdateId(c.Id, bson.M{"$set": set})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't update collection")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+func collectionDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, c, msg := ownCollection(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	err := ds.C("Collections").RemoveId(c.Id)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete collection")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+func collectionItemAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, c, msg := ownCollection(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	id := req.FormValue("article")
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	n, err := ds.C("Articles").FindId(bson.ObjectIdHex(id)).Count()
+	if err != nil || n == 0 {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+	if len(c.Items) >= maxCollectionItems {
+		respondWithError(w, http.StatusBadRequest, "Too many articles in collection")
+		return
+	}
+	err = ds.C("Collections").Update(bson.M{"_id": c.Id, "items.article": bson.M{"$ne": bson.ObjectIdHex(id)}}, bson.M{
+		"$push": bson.M{"items": CollectionItem{bson.ObjectIdHex(id), time.Now()}},
+		"$set":  bson.M{"updated": time.Now()},
+	})
+	if err != nil && err != mgo.ErrNotFound {
+		respondWithError(w, http.StatusBadRequest, "Can't add this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully added")
+}
+
+func collectionItemDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, c, msg := ownCollection(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	id := mux.Vars(req)["article"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err := ds.C("Collections").UpdateId(c.Id, bson.M{
+		"$pull": bson.M{"items": bson.M{"article": bson.ObjectIdHex(id)}},
+		"$set":  bson.M{"updated": time.Now()},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// collectionOrder sets the order of the articles, the form lists every
+// article of the collection once
+func collectionOrder(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, c, msg := ownCollection(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	req.ParseForm()
+	ids := req.Form["article"]
+	items := make(map[string]CollectionItem)
+	for _, i := range c.Items {
+		items[i.Article.Hex()] = i
+	}
+	if len(ids) != len(items) {
+		respondWithError(w, http.StatusBadRequest, "Order must list every article")
+		return
+	}
+	ordered := make([]CollectionItem, 0, len(ids))
+	for _, id := range ids {
+		i, ok := items[id]
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Order must list every article")
+			return
+		}
+		ordered = append(ordered, i)
+		delete(items, id)
+	}
+	// the guard keeps an article added in between from being lost
+	err := ds.C("Collections").Update(bson.M{"_id": c.Id, "items": bson.M{"$size": len(ordered)}},
+		bson.M{"$set": bson.M{"items": ordered, "updated": time.Now()}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't reorder collection")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+// sharedCollection is the read-only view of a link or public
+// collection, it needs no login
+func sharedCollection(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	token := mux.Vars(req)["token"]
+	var c Collection
+	err := ds.C("Collections").Find(bson.M{"shareToken": token, "visibility": bson.M{"$ne": visibilityPrivate}}).One(&c)
+	if err != nil || token == "" {
+		respondWithError(w, http.StatusNotFound, "Can't find collection")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, collectionView(ds, c, nil, false))
+}
+
+// publicCollections lists public collections with their share tokens
+func publicCollections(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	var list []Collection
+	err := ds.C("Collections").Find(bson.M{"visibility": visibilityPublic}).Sort("-updated").Limit(100).All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find collections")
+		return
+	}
+	res := []PublicCollection{}
+	for _, c := range list {
+		res = append(res, PublicCollection{c.Name, c.Description, c.ShareToken, len(c.Items)})
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
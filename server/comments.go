@@ -0,0 +1,498 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday/v2"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	maxCommentLen   = 5000
+	maxCommentDepth = 5
+)
+
+// Comment is a Markdown comment on an article, HTML is the sanitized
+// rendering of Body. A comment of a workspace is seen by its members
+// only, without Workspace it is public. Author is the profile name or
+// the masked email of User, the email itself is not sent out.
+type Comment struct {
+	Id        bson.ObjectId `bson:"_id"`
+	Article   bson.ObjectId `bson:"article"`
+	Parent    bson.ObjectId `bson:"parent,omitempty"`
+	Depth     int           `bson:"depth"`
+	Workspace bson.ObjectId `bson:"workspace,omitempty"`
+	User      bson.ObjectId `bson:"user"`
+	Email     string        `bson:"email" json:"-"`
+	Author    string        `bson:"-"`
+	Body      string        `bson:"body"`
+	HTML      string        `bson:"html"`
+	Created   time.Time     `bson:"created"`
+	Edited    time.Time     `bson:"edited,omitempty"`
+	Deleted   bool          `bson:"deleted"`
+	Hidden    bool          `bson:"hidden"`
+	Reports   int           `bson:"reports"`
+}
+
+// CommentReport is a complaint about a comment, one per user
+type CommentReport struct {
+	Id      string        `bson:"_id"`
+	Comment bson.ObjectId `bson:"comment"`
+	User    bson.ObjectId `bson:"user"`
+	Email   string        `bson:"email"`
+	Reason  string        `bson:"reason"`
+	Created time.Time     `bson:"created"`
+}
+
+// CommentView is a comment with its replies as the user sees it
+type CommentView struct {
+	Comment
+	Team    string
+	Mine    bool
+	Replies []*CommentView
+}
+
+// ModerationItem is a reported comment in the moderation queue
+type ModerationItem struct {
+	Comment
+	Title   string
+	Reasons []CommentReport
+}
+
+var commentPolicy = bluemonday.UGCPolicy().AddTargetBlankToFullyQualifiedLinks(true)
+
+func renderMarkdown(body string) string {
+	html := blackfriday.Run([]byte(body), blackfriday.WithExtensions(blackfriday.CommonExtensions|blackfriday.HardLineBreak))
+	return string(commentPolicy.SanitizeBytes(html))
+}
+
+// moderatorHandler lets admins and moderators through
+func moderatorHandler(next http.HandlerFunc) http.HandlerFunc {
+	return restrictedHandler(func(w http.ResponseWriter, req *http.Request) {
+		ds := NewDataStore()
+		defer ds.Close()
+		user, err := currentUser(ds, req)
+		if err != nil || !(user.Admin || user.Moderator) {
+			respondWithError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+		next(w, req)
+	})
+}
+
+// userWorkspaces returns the ids and names of the workspaces of the user
+func userWorkspaces(ds *DataStore, user User) map[bson.ObjectId]string {
+	var list []Workspace
+	ds.C("Workspaces").Find(bson.M{"members.user": user.Id}).Select(bson.M{"name": 1}).All(&list)
+	names := make(map[bson.ObjectId]string)
+	for _, ws := range list {
+		names[ws.Id] = ws.Name
+	}
+	return names
+}
+
+// commentAuthors sets the author names of the comments, the email of
+// the author is never shown to other users
+func commentAuthors(ds *DataStore, list []Comment) {
+	var ids []bson.ObjectId
+	for _, c := range list {
+		ids = append(ids, c.User)
+	}
+	var users []User
+	ds.C("Users").Find(bson.M{"_id": bson.M{"$in": ids}}).
+		Select(bson.M{"email": 1, "publicProfile": 1, "profileName": 1}).All(&users)
+	names := make(map[bson.ObjectId]string)
+	for _, u := range users {
+		names[u.Id] = authorName(u)
+	}
+	for i := range list {
+		list[i].Author = names[list[i].User]
+		if list[i].Author == "" {
+			list[i].Author = maskEmail(list[i].Email)
+		}
+	}
+}
+
+// authorName is the public profile name of the user or the masked email
+func authorName(u User) string {
+	if u.PublicProfile && u.ProfileName != "" {
+		return u.ProfileName
+	}
+	return maskEmail(u.Email)
+}
+
+// maskEmail keeps the first letter of the address: "i***"
+func maskEmail(email string) string {
+	r := []rune(email)
+	if len(r) == 0 || r[0] == '@' {
+		return "***"
+	}
+	return string(r[0]) + "***"
+}
+
+// visibleComments is the query of the comments the user may read
+func visibleComments(teams map[bson.ObjectId]string) bson.M {
+	ids := []interface{}{nil}
+	for id := range teams {
+		ids = append(ids, id)
+	}
+	return bson.M{"workspace": bson.M{"$in": ids}}
+}
+
+// commentCounts sets the number of visible comments of every article of f
+func commentCounts(ds *DataStore, user User, f []ArticleFeed) {
+	if len(f) == 0 {
+		return
+	}
+	var ids []bson.ObjectId
+	for _, a := range f {
+		ids = append(ids, a.Id)
+	}
+	q := visibleComments(userWorkspaces(ds, user))
+	q["article"] = bson.M{"$in": ids}
+	q["deleted"] = false
+	q["hidden"] = false
+	var counts []struct {
+		Id bson.ObjectId `bson:"_id"`
+		N  int           `bson:"n"`
+	}
+	err := ds.C("Comments").Pipe([]bson.M{
+		{"$match": q},
+		{"$group": bson.M{"_id": "$article", "n": bson.M{"$sum": 1}}},
+	}).All(&counts)
+	if err != nil {
+		log.Println("comment counts err: ", err)
+		return
+	}
+	n := make(map[bson.ObjectId]int)
+	for _, c := range counts {
+		n[c.Id] = c.N
+	}
+	for i := range f {
+		f[i].Comments = n[f[i].Id]
+	}
+}
+
+func commentsList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	teams := userWorkspaces(ds, user)
+	q := visibleComments(teams)
+	q["article"] = bson.ObjectIdHex(id)
+	var list []Comment
+	err = ds.C("Comments").Find(q).Sort("created").All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find comments")
+		return
+	}
+	commentAuthors(ds, list)
+	respondWithJSON(w, http.StatusOK, commentTree(list, user, teams))
+}
+
+// commentTree nests the replies under their parents, the text of
+// deleted and hidden comments is dropped
+func commentTree(list []Comment, user User, teams map[bson.ObjectId]string) []*CommentView {
+	roots := []*CommentView{}
+	views := make(map[bson.ObjectId]*CommentView)
+	for _, c := range list {
+		v := &CommentView{Comment: c, Team: teams[c.Workspace], Mine: c.User == user.Id}
+		if c.Deleted || c.Hidden {
+			v.Body, v.HTML, v.Mine = "", "", false
+		} else if !v.Mine {
+			v.Body = ""
+		}
+		v.Reports = 0
+		views[c.Id] = v
+		if p, ok := views[c.Parent]; ok {
+			p.Replies = append(p.Replies, v)
+		} else {
+			roots = append(roots, v)
+		}
+	}
+	return roots
+}
+
+// commentAdd adds a comment to the article or a reply to the parent
+// comment, a reply takes the article and the workspace of the parent
+func commentAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	c := Comment{
+		Id:      bson.NewObjectId(),
+		Article: bson.ObjectIdHex(id),
+		User:    user.Id,
+		Email:   user.Email,
+		Author:  authorName(user),
+		Body:    strings.TrimSpace(req.FormValue("body")),
+		Created: time.Now(),
+	}
+	if c.Body == "" || utf8.RuneCountInString(c.Body) > maxCommentLen {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment")
+		return
+	}
+	teams := userWorkspaces(ds, user)
+	if parent := req.FormValue("parent"); parent != "" {
+		if !bson.IsObjectIdHex(parent) {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		var p Comment
+		q := visibleComments(teams)
+		q["_id"] = bson.ObjectIdHex(parent)
+		if ds.C("Comments").Find(q).One(&p) != nil || p.Article != c.Article {
+			respondWithError(w, http.StatusBadRequest, "Can't find comment")
+			return
+		}
+		if p.Deleted || p.Hidden {
+			respondWithError(w, http.StatusBadRequest, "Comment is deleted")
+			return
+		}
+		// too deep replies go next to the parent
+		if p.Depth >= maxCommentDepth && p.Parent != "" {
+			c.Parent, c.Depth = p.Parent, p.Depth
+		} else {
+			c.Parent, c.Depth = p.Id, p.Depth+1
+		}
+		c.Workspace = p.Workspace
+	} else {
+		if n, _ := ds.C("Articles").FindId(c.Article).Count(); n == 0 {
+			respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+			return
+		}
+		if ws := req.FormValue("workspace"); ws != "" {
+			if !bson.IsObjectIdHex(ws) || teams[bson.ObjectIdHex(ws)] == "" {
+				respondWithError(w, http.StatusForbidden, "Access denied")
+				return
+			}
+			c.Workspace = bson.ObjectIdHex(ws)
+		}
+	}
+	c.HTML = renderMarkdown(c.Body)
+	err = ds.C("Comments").Insert(c)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save comment")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, c)
+}
+
+// ownComment loads the {id} comment if it is written by the user
+func ownComment(ds *DataStore, user User, id string) (Comment, bool) {
+	var c Comment
+	if !bson.IsObjectIdHex(id) {
+		return c, false
+	}
+	err := ds.C("Comments").Find(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id, "deleted": false, "hidden": false}).One(&c)
+	return c, err == nil
+}
+
+func commentChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	c, ok := ownComment(ds, user, mux.Vars(req)["id"])
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Can't find comment")
+		return
+	}
+	body := strings.TrimSpace(req.FormValue("body"))
+	if body == "" || utf8.RuneCountInString(body) > maxCommentLen {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment")
+		return
+	}
+	err = ds.C("Comments").UpdateId(c.Id, bson.M{"$set": bson.M{"body": body, "html": renderMarkdown(body), "edited": time.Now()}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save comment")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+func commentDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	c, ok := ownComment(ds, user, mux.Vars(req)["id"])
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Can't find comment")
+		return
+	}
+	err = removeComment(ds, c)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete comment")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// removeComment drops a comment without replies, a comment with replies
+// stays in the thread without its text
+func removeComment(ds *DataStore, c Comment) error {
+	cc := ds.C("Comments")
+	ds.C("CommentReports").RemoveAll(bson.M{"comment": c.Id})
+	n, err := cc.Find(bson.M{"parent": c.Id}).Count()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return cc.RemoveId(c.Id)
+	}
+	return cc.UpdateId(c.Id, bson.M{"$set": bson.M{"deleted": true, "body": "", "html": "", "reports": 0}})
+}
+
+func commentReport(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	var c Comment
+	q := visibleComments(userWorkspaces(ds, user))
+	q["_id"] = bson.ObjectIdHex(id)
+	q["deleted"] = false
+	q["hidden"] = false
+	if ds.C("Comments").Find(q).One(&c) != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find comment")
+		return
+	}
+	if c.User == user.Id {
+		respondWithError(w, http.StatusBadRequest, "Can't report own comment")
+		return
+	}
+	r := CommentReport{
+		Id:      c.Id.Hex() + user.Id.Hex(),
+		Comment: c.Id,
+		User:    user.Id,
+		Email:   user.Email,
+		Reason:  strings.TrimSpace(req.FormValue("reason")),
+		Created: time.Now(),
+	}
+	err = ds.C("CommentReports").Insert(r)
+	if mgo.IsDup(err) {
+		respondWithJSON(w, http.StatusOK, "Already reported")
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save report")
+		return
+	}
+	ds.C("Comments").UpdateId(c.Id, bson.M{"$inc": bson.M{"reports": 1}})
+	respondWithJSON(w, http.StatusOK, "Successfully reported")
+}
+
+// moderationQueue lists reported comments, most reported first. The
+// comments of a workspace are left to moderators who are its members.
+func moderationQueue(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var list []Comment
+	q := visibleComments(userWorkspaces(ds, user))
+	q["reports"] = bson.M{"$gt": 0}
+	q["deleted"] = false
+	q["hidden"] = false
+	err = ds.C("Comments").Find(q).Sort("-reports", "created").Limit(100).All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find comments")
+		return
+	}
+	commentAuthors(ds, list)
+	res := []ModerationItem{}
+	for _, c := range list {
+		item := ModerationItem{Comment: c}
+		var a Article
+		if ds.C("Articles").FindId(c.Article).Select(bson.M{"title": 1}).One(&a) == nil {
+			item.Title = a.Title
+		}
+		ds.C("CommentReports").Find(bson.M{"comment": c.Id}).Sort("created").All(&item.Reasons)
+		res = append(res, item)
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// moderationDecide hides a reported comment or dismisses its reports
+func moderationDecide(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	var set bson.M
+	switch req.FormValue("action") {
+	case "hide":
+		set = bson.M{"hidden": true}
+	case "dismiss":
+		set = bson.M{"reports": 0}
+	default:
+		respondWithError(w, http.StatusBadRequest, "Unknown action")
+		return
+	}
+	q := visibleComments(userWorkspaces(ds, user))
+	q["_id"] = bson.ObjectIdHex(id)
+	err = ds.C("Comments").Update(q, bson.M{"$set": set})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find comment")
+		return
+	}
+	ds.C("CommentReports").RemoveAll(bson.M{"comment": bson.ObjectIdHex(id)})
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
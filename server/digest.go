@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+
+	digestSize = 10
+)
+
+type DigestSettings struct {
+	Enabled     bool      `bson:"enabled"`
+	Frequency   string    `bson:"frequency"`
+	Hour        int       `bson:"hour"`
+	TimeZone    string    `bson:"timeZone"`
+	LastSent    time.Time `bson:"lastSent"`
+	Unsubscribe string    `bson:"unsubscribe"`
+}
+
+var (
+	digestHTML = htmltemplate.Must(htmltemplate.ParseFiles("templates/digest.html"))
+	digestText = texttemplate.Must(texttemplate.ParseFiles("templates/digest.txt"))
+	publicURL  = getEnv("PUBLIC_URL", "https://nefeed.ga")
+)
+
+// digestScheduler sends due digests, it never returns
+func digestScheduler(m Mailer) {
+	timer := time.NewTicker(time.Minute * 5)
+	for range timer.C {
+		sendDigests(m, time.Now())
+	}
+}
+
+func sendDigests(m Mailer, now time.Time) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Users")
+
+	var users []User
+	err := c.Find(bson.M{"digest.enabled": true}).All(&users)
+	if err != nil {
+		log.Println("digest users err: ", err)
+		return
+	}
+	for _, user := range users {
+		if !digestDue(user.Digest, now) {
+			continue
+		}
+		// claim the slot first so a slow mailer can't send twice
+		err = c.Update(bson.M{"_id": user.Id, "digest.lastSent": user.Digest.LastSent},
+			bson.M{"$set": bson.M{"digest.lastSent": now}})
+		if err != nil {
+			continue
+		}
+		err = sendDigest(ds, m, user, now)
+		if err != nil {
+			log.Printf("digest for %s err: %v\n", user.Email, err)
+		}
+	}
+}
+
+// digestDue reports whether the latest scheduled slot is after the last sending
+func digestDue(d DigestSettings, now time.Time) bool {
+	loc, err := time.LoadLocation(d.TimeZone)
+	if err != nil {
+		loc, _ = time.LoadLocation("Europe/Moscow")
+	}
+	local := now.In(loc)
+	slot := time.Date(local.Year(), local.Month(), local.Day(), d.Hour, 0, 0, 0, loc)
+	if local.Before(slot) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	if d.Frequency == DigestWeekly {
+		for slot.Weekday() != time.Monday {
+			slot = slot.AddDate(0, 0, -1)
+		}
+	}
+	return d.LastSent.Before(slot)
+}
+
+func sendDigest(ds *DataStore, m Mailer, user User, now time.Time) error {
+	period := 24 * time.Hour
+	if user.Digest.Frequency == DigestWeekly {
+		period = 7 * 24 * time.Hour
+	}
+	articles, err := digestArticles(ds, user, now.Add(-period))
+	if err != nil || len(articles) == 0 {
+		return err
+	}
+	data := struct {
+		Articles    []Article
+		Date        string
+		Site        string
+		Unsubscribe string
+	}{
+		articles,
+		now.Format("02.01.2006"),
+		publicURL,
+		publicURL + "/digest/unsubscribe/" + user.Digest.Unsubscribe,
+	}
+	var text, html bytes.Buffer
+	err = digestText.Execute(&text, data)
+	if err != nil {
+		return err
+	}
+	err = digestHTML.Execute(&html, data)
+	if err != nil {
+		return err
+	}
+	return m.Send(Mail{
+		To:          user.Email,
+		Subject:     "NeFeed: главное за " + data.Date,
+		Text:        text.String(),
+		HTML:        html.String(),
+		Unsubscribe: data.Unsubscribe,
+	})
+}
+
+// digestArticles takes the toDayFeed selection, falling back to the tag feed
+func digestArticles(ds *DataStore, user User, since time.Time) ([]Article, error) {
+	articles := feedSince(ds, user, since)
+	if len(articles) == 0 {
+		err := ds.C("Articles").Find(bson.M{"tags": bson.M{"$in": user.Tags}, "timestamp": bson.M{"$gte": since}}).
+			Sort("-timestamp").All(&articles)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var selected []Article
+	mutes := compileMutes(user.Mutes)
+	for _, a := range articles {
+		if mutedBy(mutes, a) != nil {
+			continue
+		}
+		selected = append(selected, a)
+		if len(selected) == digestSize {
+			break
+		}
+	}
+	return selected, nil
+}
+
+func digestSettings(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user.Digest)
+}
+
+func digestSettingsChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	err = req.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't parse form")
+		return
+	}
+	d := user.Digest
+	d.Enabled = req.FormValue("enabled") == "1"
+	d.Frequency = req.FormValue("frequency")
+	if d.Frequency != DigestDaily && d.Frequency != DigestWeekly {
+		respondWithError(w, http.StatusBadRequest, "Unknown frequency")
+		return
+	}
+	d.Hour, err = strconv.Atoi(req.FormValue("hour"))
+	if err != nil || d.Hour < 0 || d.Hour > 23 {
+		respondWithError(w, http.StatusBadRequest, "Invalid hour")
+		return
+	}
+	d.TimeZone = req.FormValue("timezone")
+	_, err = time.LoadLocation(d.TimeZone)
+	if err != nil || d.TimeZone == "" {
+		respondWithError(w, http.StatusBadRequest, "Unknown time zone")
+		return
+	}
+	if d.Unsubscribe == "" {
+		d.Unsubscribe = randomToken()
+	}
+	// the first digest goes out at the next slot, not right now
+	d.LastSent = time.Now()
+	err = ds.C("Users").Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"digest": d}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save settings")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, d)
+}
+
+// digestUnsubscribe is the one-click link from the letter, it needs no login
+func digestUnsubscribe(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	token := mux.Vars(req)["token"]
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid token")
+		return
+	}
+	err := ds.C("Users").Update(bson.M{"digest.unsubscribe": token}, bson.M{"$set": bson.M{"digest.enabled": false}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find subscription")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully unsubscribed")
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
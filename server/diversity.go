@@ -0,0 +1,331 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DiversitySettings controls the re-ranking of feed pages. Zero values
+// of a user's settings fall back to the server defaults, diversityOff
+// turns the source cap or the duplicate check off for the user.
+type DiversitySettings struct {
+	// most articles of one source in a row
+	MaxRun int `bson:"maxRun"`
+	// "on" or "off", mixes tags by the user's likes
+	Interleave string `bson:"interleave"`
+	// title similarity from which stories count as duplicates
+	DupThreshold float64 `bson:"dupThreshold"`
+}
+
+const (
+	// tags are mixed within blocks, so rare tags don't pull old articles up
+	interleaveBlock = 30
+	// articles past the requested page which are re-ranked too, so the
+	// end of the page doesn't depend on where the window is cut
+	rerankMargin = interleaveBlock
+	// only stories this close in time are compared for duplicates
+	dupWindow = 48 * time.Hour
+	// MaxRun or DupThreshold set by the user to turn the step off, zero
+	// is the server default
+	diversityOff = -1
+)
+
+var defaultDiversity = DiversitySettings{
+	MaxRun:       envInt("FEED_MAX_RUN", 2),
+	Interleave:   getEnv("FEED_INTERLEAVE", "on"),
+	DupThreshold: envFloat("FEED_DUP_THRESHOLD", 0.6),
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func envFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(getEnv(key, ""), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// withDefaults fills unset fields from the server settings, diversityOff
+// is kept and skips the step in rerank
+func (s DiversitySettings) withDefaults() DiversitySettings {
+	if s.MaxRun == 0 {
+		s.MaxRun = defaultDiversity.MaxRun
+	}
+	if s.Interleave == "" {
+		s.Interleave = defaultDiversity.Interleave
+	}
+	if s.DupThreshold == 0 {
+		s.DupThreshold = defaultDiversity.DupThreshold
+	}
+	return s
+}
+
+// rerankWindow is the number of articles to re-rank for the feed to
+// show n of them, it is cut at a block of interleaveBlock so every page
+// sees the same blocks
+func rerankWindow(n int) int {
+	n += rerankMargin
+	return (n + interleaveBlock - 1) / interleaveBlock * interleaveBlock
+}
+
+// diversify re-ranks the first window of articles sorted newest first:
+// near duplicates are dropped, tags are interleaved and runs of one
+// source are broken up. The articles past the window are kept as they are.
+func diversify(ds *DataStore, user User, articles []Article, window int) []Article {
+	var tail []Article
+	if window < len(articles) {
+		articles, tail = articles[:window], articles[window:]
+	}
+	articles = rerank(ds, user, articles)
+	return append(articles, tail...)
+}
+
+func rerank(ds *DataStore, user User, articles []Article) []Article {
+	s := user.Diversity.withDefaults()
+	if s.DupThreshold > 0 && s.DupThreshold < 1 {
+		articles = dropDuplicates(articles, s.DupThreshold)
+	}
+	if s.Interleave == "on" {
+		weights := tagWeights(ds, user)
+		var mixed []Article
+		for i := 0; i < len(articles); i += interleaveBlock {
+			end := i + interleaveBlock
+			if end > len(articles) {
+				end = len(articles)
+			}
+			mixed = append(mixed, interleaveTags(articles[i:end], user.Tags, weights)...)
+		}
+		articles = mixed
+	}
+	if s.MaxRun > 0 {
+		articles = capRuns(articles, s.MaxRun)
+	}
+	return articles
+}
+
+func titleWords(title string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(w)) > 3 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// wordsSimilarity is the Jaccard index of title words, titles of a
+// couple of words are too short to tell duplicates
+func wordsSimilarity(a, b map[string]bool) float64 {
+	if len(a) < 3 || len(b) < 3 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// dropDuplicates keeps the newest of stories with similar titles
+func dropDuplicates(articles []Article, threshold float64) []Article {
+	var (
+		kept  []Article
+		words []map[string]bool
+	)
+	for _, a := range articles {
+		w := titleWords(a.Title)
+		dup := false
+		for i := len(kept) - 1; i >= 0 && kept[i].Timestamp.Sub(a.Timestamp) < dupWindow; i-- {
+			if wordsSimilarity(w, words[i]) >= threshold {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			kept = append(kept, a)
+			words = append(words, w)
+		}
+	}
+	return kept
+}
+
+// tagWeights weighs the user's tags by recent likes, every tag has at least 1
+func tagWeights(ds *DataStore, user User) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, t := range user.Tags {
+		weights[t] = 1
+	}
+	liked := user.LikeNews
+	if len(liked) > 200 {
+		liked = liked[len(liked)-200:]
+	}
+	var articles []Article
+	err := ds.C("Articles").Find(bson.M{"_id": bson.M{"$in": liked}}).Select(bson.M{"tags": 1}).All(&articles)
+	if err != nil {
+		log.Println("tag weights err: ", err)
+	}
+	for _, a := range articles {
+		for _, t := range a.Tags {
+			if _, ok := weights[t]; ok {
+				weights[t]++
+			}
+		}
+	}
+	return weights
+}
+
+// interleaveTags takes articles from the tag which got the smallest
+// share of its weight so far, keeping the order within a tag
+func interleaveTags(articles []Article, tags []string, weights map[string]float64) []Article {
+	buckets := make(map[string][]Article)
+	var order []string
+	for _, a := range articles {
+		tag := ""
+		for _, t := range a.Tags {
+			if contains(tags, t) {
+				tag = t
+				break
+			}
+		}
+		if _, ok := buckets[tag]; !ok {
+			order = append(order, tag)
+		}
+		buckets[tag] = append(buckets[tag], a)
+	}
+	served := make(map[string]float64)
+	res := make([]Article, 0, len(articles))
+	for len(res) < len(articles) {
+		best := ""
+		bestShare := -1.0
+		for _, t := range order {
+			if len(buckets[t]) == 0 {
+				continue
+			}
+			w := weights[t]
+			if w == 0 {
+				w = 1
+			}
+			if share := served[t] / w; bestShare < 0 || share < bestShare {
+				best, bestShare = t, share
+			}
+		}
+		res = append(res, buckets[best][0])
+		buckets[best] = buckets[best][1:]
+		served[best]++
+	}
+	return res
+}
+
+// capRuns moves articles back so no more than maxRun of one source
+// follow each other, unless nothing else is left. Articles moved back
+// wait in a queue and go out first as soon as their source may follow.
+// Only the source of the run is ever moved back, so one queue is enough
+// and the pass is linear.
+func capRuns(articles []Article, maxRun int) []Article {
+	res := make([]Article, 0, len(articles))
+	var waiting []Article
+	head, next, run := 0, 0, 0
+	push := func(a Article) {
+		if n := len(res); n > 0 && res[n-1].Source == a.Source {
+			run++
+		} else {
+			run = 1
+		}
+		res = append(res, a)
+	}
+	for len(res) < len(articles) {
+		blocked := func(a Article) bool { return run >= maxRun && a.Source == res[len(res)-1].Source }
+		if head < len(waiting) && !blocked(waiting[head]) {
+			push(waiting[head])
+			head++
+			continue
+		}
+		for next < len(articles) && blocked(articles[next]) {
+			waiting = append(waiting, articles[next])
+			next++
+		}
+		if next < len(articles) {
+			push(articles[next])
+			next++
+		} else {
+			push(waiting[head])
+			head++
+		}
+	}
+	return res
+}
+
+func diversitySettings(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		User    DiversitySettings
+		Default DiversitySettings
+	}{user.Diversity, defaultDiversity})
+}
+
+// diversitySettingsChange saves the user's overrides, empty fields
+// mean the server default, maxRunOff and dupOff turn the steps off
+func diversitySettingsChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var s DiversitySettings
+	if req.FormValue("maxRunOff") == "1" {
+		s.MaxRun = diversityOff
+	} else if v := req.FormValue("maxRun"); v != "" {
+		s.MaxRun, err = strconv.Atoi(v)
+		if err != nil || s.MaxRun < 1 || s.MaxRun > 10 {
+			respondWithError(w, http.StatusBadRequest, "Invalid max run")
+			return
+		}
+	}
+	s.Interleave = req.FormValue("interleave")
+	if s.Interleave != "" && s.Interleave != "on" && s.Interleave != "off" {
+		respondWithError(w, http.StatusBadRequest, "Invalid interleave")
+		return
+	}
+	if req.FormValue("dupOff") == "1" {
+		s.DupThreshold = diversityOff
+	} else if v := req.FormValue("dupThreshold"); v != "" {
+		s.DupThreshold, err = strconv.ParseFloat(v, 64)
+		if err != nil || s.DupThreshold <= 0 || s.DupThreshold > 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid duplicate threshold")
+			return
+		}
+	}
+	err = ds.C("Users").Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"diversity": s}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save settings")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, s)
+}
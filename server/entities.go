@@ -0,0 +1,264 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	popularEntities    = 30
+	popularEntityDays  = 7
+	entityFollowNotify = "inapp"
+)
+
+// Keyword is a word of an article weighted by tf-idf, articaleServer
+// extracts them at ingestion
+type Keyword struct {
+	Word  string  `bson:"word"`
+	Score float64 `bson:"score"`
+}
+
+// Entity is a person, an organization or a place named in an article.
+// Key is the kind and the stemmed name, it is the same for the case
+// forms of the name.
+type Entity struct {
+	Key   string `bson:"key"`
+	Name  string `bson:"name"`
+	Kind  string `bson:"kind"`
+	Count int    `bson:"count"`
+}
+
+// EntityView is an entity with the number of articles naming it and
+// the saved search which follows it
+type EntityView struct {
+	Entity
+	Articles  int
+	Following bool
+	Search    bson.ObjectId `json:",omitempty"`
+}
+
+type EntitiesPage struct {
+	Following []EntityView
+	Popular   []EntityView
+}
+
+var entityKeyRe = regexp.MustCompile(`^(person|org|place):[^/\s]+$`)
+
+func ensureEntityIndex() {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	err := ds.C("Articles").EnsureIndex(mgo.Index{Key: []string{"entities.key", "-timestamp"}})
+	if err != nil {
+		log.Println("entities index err: ", err)
+	}
+}
+
+// followedEntities maps the keys of the entities followed by the user
+// to their saved searches
+func followedEntities(ds *DataStore, user User) map[string]SavedSearch {
+	var searches []SavedSearch
+	ds.C("SavedSearches").Find(bson.M{"user": user.Id, "query.entities.0": bson.M{"$exists": true}}).All(&searches)
+	res := make(map[string]SavedSearch)
+	for _, s := range searches {
+		if len(s.Query.Entities) == 1 && s.Query.Text == "" && len(s.Query.Sources) == 0 && len(s.Query.Tags) == 0 {
+			res[s.Query.Entities[0]] = s
+		}
+	}
+	return res
+}
+
+// findEntity returns the entity of the key as it is named in its latest
+// article
+func findEntity(ds *DataStore, key string) (EntityView, error) {
+	var v EntityView
+	var a Article
+	err := ds.C("Articles").Find(bson.M{"entities.key": key}).Select(bson.M{"entities": 1}).Sort("-timestamp").One(&a)
+	if err != nil {
+		return v, err
+	}
+	for _, e := range a.Entities {
+		if e.Key == key {
+			v.Entity = e
+		}
+	}
+	v.Articles, err = ds.C("Articles").Find(bson.M{"entities.key": key}).Count()
+	return v, err
+}
+
+// entitiesList returns the followed entities and the entities named in
+// most articles of the last popularEntityDays
+func entitiesList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	followed := followedEntities(ds, user)
+	res := EntitiesPage{Following: []EntityView{}, Popular: []EntityView{}}
+	for key, s := range followed {
+		v, err := findEntity(ds, key)
+		if err != nil {
+			v.Key, v.Name = key, s.Name
+		}
+		v.Following, v.Search = true, s.Id
+		res.Following = append(res.Following, v)
+	}
+	sort.Slice(res.Following, func(i, j int) bool { return res.Following[i].Name < res.Following[j].Name })
+	var popular []struct {
+		Key  string `bson:"_id"`
+		Name string `bson:"name"`
+		Kind string `bson:"kind"`
+		N    int    `bson:"n"`
+	}
+	err = ds.C("Articles").Pipe([]bson.M{
+		{"$match": bson.M{"timestamp": bson.M{"$gte": time.Now().AddDate(0, 0, -popularEntityDays)}}},
+		{"$project": bson.M{"entities": 1}},
+		{"$unwind": "$entities"},
+		{"$group": bson.M{"_id": "$entities.key", "name": bson.M{"$last": "$entities.name"},
+			"kind": bson.M{"$last": "$entities.kind"}, "n": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"n": -1}},
+		{"$limit": popularEntities},
+	}).All(&popular)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find entities")
+		return
+	}
+	for _, p := range popular {
+		s, ok := followed[p.Key]
+		res.Popular = append(res.Popular, EntityView{Entity{Key: p.Key, Name: p.Name, Kind: p.Kind}, p.N, ok, s.Id})
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func entityGet(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	key := mux.Vars(req)["key"]
+	if !entityKeyRe.MatchString(key) {
+		respondWithError(w, http.StatusBadRequest, "Invalid entity")
+		return
+	}
+	v, err := findEntity(ds, key)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Can't find entity")
+		return
+	}
+	if s, ok := followedEntities(ds, user)[key]; ok {
+		v.Following, v.Search = true, s.Id
+	}
+	respondWithJSON(w, http.StatusOK, v)
+}
+
+// entityFeed lists the articles naming the entity, the latest first
+func entityFeed(w http.ResponseWriter, req *http.Request) {
+	var articles []Article
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	vars := mux.Vars(req)
+	pageInt, err := strconv.Atoi(vars["page"])
+	if err != nil || !entityKeyRe.MatchString(vars["key"]) {
+		respondWithError(w, http.StatusBadRequest, "Can't find this page")
+		return
+	}
+	err = ds.C("Articles").Find(bson.M{"entities.key": vars["key"]}).Sort("-timestamp").All(&articles)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find articles")
+		return
+	}
+	writeArticlePage(w, ds, user, articles, pageInt, req.URL.Query().Get("muted") == "1")
+}
+
+// entityFollow subscribes the user to the entity with a saved search,
+// so new articles naming it come with notifications and a search feed
+func entityFollow(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("SavedSearches")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	key := mux.Vars(req)["key"]
+	if !entityKeyRe.MatchString(key) {
+		respondWithError(w, http.StatusBadRequest, "Invalid entity")
+		return
+	}
+	if s, ok := followedEntities(ds, user)[key]; ok {
+		respondWithJSON(w, http.StatusOK, s)
+		return
+	}
+	v, err := findEntity(ds, key)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Can't find entity")
+		return
+	}
+	n, err := c.Find(bson.M{"user": user.Id}).Count()
+	if err != nil || n >= maxSavedSearches {
+		respondWithError(w, http.StatusBadRequest, "Too many saved searches")
+		return
+	}
+	s := SavedSearch{
+		Id:      bson.NewObjectId(),
+		User:    user.Id,
+		Name:    v.Name,
+		Query:   Query{Entities: []string{key}},
+		Notify:  []string{entityFollowNotify},
+		Created: time.Now(),
+	}
+	err = c.Insert(s)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't follow entity")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, s)
+}
+
+func entityUnfollow(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	key := mux.Vars(req)["key"]
+	s, ok := followedEntities(ds, user)[key]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Entity is not followed")
+		return
+	}
+	err = ds.C("SavedSearches").RemoveId(s.Id)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't unfollow entity")
+		return
+	}
+	ds.C("SearchMatches").RemoveAll(bson.M{"search": s.Id})
+	respondWithJSON(w, http.StatusOK, "Successfully unfollowed")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// actions of the interaction log
+const (
+	actionLike       = "like"
+	actionDislike    = "dislike"
+	actionUnrate     = "unrate"
+	actionOpen       = "open"
+	actionBookmark   = "bookmark"
+	actionUnbookmark = "unbookmark"
+	actionClick      = "click"
+	actionRead       = "read"
+	actionShare      = "share"
+)
+
+// Client describes where an interaction came from, frontEnd passes
+// the browser's address and user agent along
+type Client struct {
+	IP        string `bson:"ip"`
+	UserAgent string `bson:"userAgent"`
+	Referer   string `bson:"referer"`
+}
+
+// Event is an append-only record of a user's interaction with an article.
+// Events backfilled from the rating arrays have a zero timestamp.
+type Event struct {
+	Id         bson.ObjectId `bson:"_id"`
+	User       bson.ObjectId `bson:"user"`
+	Article    bson.ObjectId `bson:"article"`
+	Action     string        `bson:"action"`
+	Timestamp  time.Time     `bson:"timestamp"`
+	Client     Client        `bson:"client"`
+	Backfilled bool          `bson:"backfilled,omitempty"`
+	Read       *ReadSignal   `bson:"read,omitempty"`
+}
+
+// clientInfo takes the address from X-Forwarded-For only when the
+// request comes from caddy or frontEnd inside of the private network.
+// Caddy appends the address it sees to the header sent by the browser,
+// so the last entry is the one to trust.
+func clientInfo(req *http.Request) Client {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if hop := net.ParseIP(ip); hop != nil && !publicIP(hop) {
+		fwd := req.Header.Get("X-Forwarded-For")
+		if i := strings.LastIndex(fwd, ","); i >= 0 {
+			fwd = fwd[i+1:]
+		}
+		if fwd = strings.TrimSpace(fwd); net.ParseIP(fwd) != nil {
+			ip = fwd
+		}
+	}
+	return Client{ip, req.UserAgent(), req.Referer()}
+}
+
+func newEvent(req *http.Request, user User, article bson.ObjectId, action string) Event {
+	return Event{
+		Id:        bson.NewObjectId(),
+		User:      user.Id,
+		Article:   article,
+		Action:    action,
+		Timestamp: time.Now(),
+		Client:    clientInfo(req),
+	}
+}
+
+func recordEvent(ds *DataStore, req *http.Request, user User, article bson.ObjectId, action string) {
+	err := ds.C("Events").Insert(newEvent(req, user, article, action))
+	if err != nil {
+		log.Println("event insert err: ", err)
+	}
+}
+
+// rateUnrate takes back a like or a dislike
+func rateUnrate(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Users")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	articleId := bson.ObjectIdHex(id)
+	removed := false
+	for field, counter := range map[string]string{"likeNews": "likes", "dislikeNews": "dislikes"} {
+		err = c.Update(bson.M{"_id": user.Id, field: articleId}, bson.M{"$pull": bson.M{field: articleId}})
+		if err == nil {
+			countEngagement(ds, articleId, counter, -1)
+			removed = true
+		} else if err != mgo.ErrNotFound {
+			respondWithError(w, http.StatusBadRequest, "Can't remove rating")
+			return
+		}
+	}
+	if removed {
+		recordEvent(ds, req, user, articleId, actionUnrate)
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully removed")
+}
+
+// backfillEvents turns the rating arrays, bookmarks and opens which
+// predate the log into events of unknown time, it runs once before the
+// server takes requests so a rating can't be logged twice
+func backfillEvents() {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Events")
+
+	c.EnsureIndex(mgo.Index{Key: []string{"user", "timestamp"}})
+	c.EnsureIndex(mgo.Index{Key: []string{"article", "action"}})
+
+	err := ds.C("Migrations").Insert(bson.M{"_id": "events-backfill", "started": time.Now()})
+	if mgo.IsDup(err) {
+		return
+	} else if err != nil {
+		log.Println("events backfill err: ", err)
+		return
+	}
+	add := func(user, article bson.ObjectId, action string) {
+		err := c.Insert(Event{Id: bson.NewObjectId(), User: user, Article: article, Action: action, Backfilled: true})
+		if err != nil {
+			log.Println("events backfill err: ", err)
+		}
+	}
+	var user User
+	iter := ds.C("Users").Find(nil).Select(bson.M{"likeNews": 1, "dislikeNews": 1, "bookmarks": 1}).Iter()
+	for iter.Next(&user) {
+		for _, id := range user.LikeNews {
+			add(user.Id, id, actionLike)
+		}
+		for _, id := range user.DislikeNews {
+			add(user.Id, id, actionDislike)
+		}
+		for _, id := range user.Bookmarks {
+			add(user.Id, id, actionBookmark)
+		}
+		user = User{}
+	}
+	if err = iter.Close(); err != nil {
+		log.Println("events backfill err: ", err)
+	}
+	var open struct {
+		Article bson.ObjectId `bson:"article"`
+		User    bson.ObjectId `bson:"user"`
+	}
+	iter = ds.C("Opens").Find(nil).Iter()
+	for iter.Next(&open) {
+		add(open.User, open.Article, actionOpen)
+	}
+	if err = iter.Close(); err != nil {
+		log.Println("events backfill err: ", err)
+	}
+}
+
+// goLink records a click-through and redirects to the stored link of
+// the article, the target never comes from the request
+func goLink(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	var a Article
+	err = ds.C("Articles").FindId(bson.ObjectIdHex(id)).Select(bson.M{"link": 1}).One(&a)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Can't find any of article")
+		return
+	}
+	if !validHTTPURL(a.Link) {
+		respondWithError(w, http.StatusBadRequest, "Invalid link")
+		return
+	}
+	recordEvent(ds, req, user, a.Id, actionClick)
+	http.Redirect(w, req, a.Link, http.StatusFound)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	maxFollowing      = 200
+	maxProfileName    = 50
+	followedLikeLimit = 100
+	profileLikes      = 20
+)
+
+// ProfileCard is a public profile in the list of people
+type ProfileCard struct {
+	Id        bson.ObjectId
+	Name      string
+	Followers int
+	Following bool
+}
+
+// Profile is a public profile with the recent likes of its owner
+type Profile struct {
+	ProfileCard
+	Me    bool
+	Likes []ArticleFeed
+}
+
+// followedLikes returns the names of the followed users with a public
+// profile who liked each article, only their recent likes are taken
+func followedLikes(ds *DataStore, user User) map[bson.ObjectId][]string {
+	liked := make(map[bson.ObjectId][]string)
+	if len(user.Following) == 0 {
+		return liked
+	}
+	var people []User
+	ds.C("Users").Find(bson.M{"_id": bson.M{"$in": user.Following}, "publicProfile": true}).
+		Select(bson.M{"profileName": 1, "likeNews": 1}).All(&people)
+	for _, p := range people {
+		likes := p.LikeNews
+		if len(likes) > followedLikeLimit {
+			likes = likes[len(likes)-followedLikeLimit:]
+		}
+		for _, id := range likes {
+			liked[id] = append(liked[id], p.ProfileName)
+		}
+	}
+	return liked
+}
+
+// followingArticles is the feed of articles liked by followed users
+func followingArticles(ds *DataStore, user User) ([]Article, error) {
+	var ids []bson.ObjectId
+	for id := range followedLikes(ds, user) {
+		ids = append(ids, id)
+	}
+	var articles []Article
+	if len(ids) == 0 {
+		return articles, nil
+	}
+	err := ds.C("Articles").Find(bson.M{"_id": bson.M{"$in": ids}}).Sort("-timestamp").All(&articles)
+	return articles, err
+}
+
+// accountProfileChange opens or closes the profile of the user, a
+// public profile needs a name
+func accountProfileChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	public := req.FormValue("public") == "1"
+	name := strings.TrimSpace(req.FormValue("name"))
+	if utf8.RuneCountInString(name) > maxProfileName || (public && name == "") {
+		respondWithError(w, http.StatusBadRequest, "Invalid name")
+		return
+	}
+	err = ds.C("Users").Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"publicProfile": public, "profileName": name}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save settings")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+func profileCard(ds *DataStore, user User, p User) ProfileCard {
+	n, _ := ds.C("Users").Find(bson.M{"following": p.Id}).Count()
+	return ProfileCard{p.Id, p.ProfileName, n, containsId(user.Following, p.Id)}
+}
+
+func containsId(list []bson.ObjectId, id bson.ObjectId) bool {
+	for _, i := range list {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// profilesList lists public profiles, the followed ones first
+func profilesList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var people []User
+	err = ds.C("Users").Find(bson.M{"publicProfile": true, "_id": bson.M{"$ne": user.Id}}).
+		Select(bson.M{"profileName": 1}).Sort("profileName").Limit(100).All(&people)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find profiles")
+		return
+	}
+	var followed, others []ProfileCard
+	for _, p := range people {
+		c := profileCard(ds, user, p)
+		if c.Following {
+			followed = append(followed, c)
+		} else {
+			others = append(others, c)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, append(append([]ProfileCard{}, followed...), others...))
+}
+
+// profileGet shows a public profile, a private one only to its owner
+func profileGet(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	var p User
+	err = ds.C("Users").FindId(bson.ObjectIdHex(id)).One(&p)
+	if err != nil || (!p.PublicProfile && p.Id != user.Id) {
+		respondWithError(w, http.StatusNotFound, "Can't find profile")
+		return
+	}
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	res := Profile{ProfileCard: profileCard(ds, user, p), Me: p.Id == user.Id, Likes: []ArticleFeed{}}
+	ca := ds.C("Articles")
+	for i := len(p.LikeNews) - 1; i >= 0 && len(res.Likes) < profileLikes; i-- {
+		var a Article
+		if ca.FindId(p.LikeNews[i]).One(&a) == nil {
+			res.Likes = append(res.Likes, newArticleFeed(a, checked))
+		}
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func follow(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) || bson.ObjectIdHex(id) == user.Id {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	if n, _ := ds.C("Users").Find(bson.M{"_id": bson.ObjectIdHex(id), "publicProfile": true}).Count(); n == 0 {
+		respondWithError(w, http.StatusNotFound, "Can't find profile")
+		return
+	}
+	if len(user.Following) >= maxFollowing {
+		respondWithError(w, http.StatusBadRequest, "Too many followed users")
+		return
+	}
+	err = ds.C("Users").UpdateId(user.Id, bson.M{"$addToSet": bson.M{"following": bson.ObjectIdHex(id)}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't follow")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully followed")
+}
+
+func unfollow(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Users").UpdateId(user.Id, bson.M{"$pull": bson.M{"following": bson.ObjectIdHex(id)}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't unfollow")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully unfollowed")
+}
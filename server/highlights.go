@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// QuoteSelector anchors a highlight by its text and some context
+// around it, so it is found again after the text is extracted anew.
+// Start is a hint for repeated quotes, in runes of the normalized text.
+type QuoteSelector struct {
+	Exact  string `bson:"exact"`
+	Prefix string `bson:"prefix"`
+	Suffix string `bson:"suffix"`
+	Start  int    `bson:"start"`
+}
+
+// Highlight is a private passage of an article with an optional note,
+// a note on the whole article has an empty selector
+type Highlight struct {
+	Id       bson.ObjectId `bson:"_id"`
+	User     bson.ObjectId `bson:"user"`
+	Article  bson.ObjectId `bson:"article"`
+	Selector QuoteSelector `bson:"selector"`
+	Note     string        `bson:"note"`
+	Created  time.Time     `bson:"created"`
+	Updated  time.Time     `bson:"updated"`
+	// filled on reads
+	Title    string `bson:"-"`
+	Link     string `bson:"-"`
+	Orphaned bool   `bson:"-"`
+}
+
+const (
+	maxQuote   = 5000
+	maxNote    = 10000
+	maxContext = 64
+)
+
+// normalizeSpace collapses runs of whitespace, the reader view and the
+// stored text differ in line breaks
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// collapseSpace is normalizeSpace keeping a space at the ends, which
+// is part of the context of a quote
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// resolveQuote finds sel in text and returns the rune offset of the
+// quote, the occurrence with the best matching context wins
+func resolveQuote(text string, sel QuoteSelector) (int, bool) {
+	text = normalizeSpace(text)
+	exact := normalizeSpace(sel.Exact)
+	prefix := collapseSpace(sel.Prefix)
+	suffix := collapseSpace(sel.Suffix)
+	if exact == "" {
+		return 0, false
+	}
+	best, bestScore := -1, -1
+	for i := 0; i <= len(text)-len(exact); {
+		j := strings.Index(text[i:], exact)
+		if j < 0 {
+			break
+		}
+		pos := i + j
+		score := commonSuffix(text[:pos], prefix) + commonPrefix(text[pos+len(exact):], suffix)
+		start := utf8.RuneCountInString(text[:pos])
+		if score > bestScore || (score == bestScore && abs(start-sel.Start) < abs(best-sel.Start)) {
+			best, bestScore = start, score
+		}
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		i = pos + size
+	}
+	return best, best >= 0
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func commonSuffix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// tail and head cut the context of a quote to maxContext runes
+func tail(s string) string {
+	r := []rune(s)
+	if len(r) > maxContext {
+		r = r[len(r)-maxContext:]
+	}
+	return string(r)
+}
+
+func head(s string) string {
+	r := []rune(s)
+	if len(r) > maxContext {
+		r = r[:maxContext]
+	}
+	return string(r)
+}
+
+// anchorHighlights resolves the selectors against the current text and
+// fills the article fields
+func anchorHighlights(ds *DataStore, list []Highlight) {
+	articles := make(map[bson.ObjectId]*Article)
+	for i := range list {
+		h := &list[i]
+		a, ok := articles[h.Article]
+		if !ok {
+			a = &Article{}
+			if ds.C("Articles").FindId(h.Article).Select(bson.M{"title": 1, "link": 1, "text": 1, "RowText": 1}).One(a) != nil {
+				a = nil
+			}
+			articles[h.Article] = a
+		}
+		if a == nil {
+			h.Orphaned = true
+			continue
+		}
+		h.Title, h.Link = a.Title, a.Link
+		if h.Selector.Exact == "" {
+			continue
+		}
+		start, found := resolveQuote(a.Text, h.Selector)
+		if !found {
+			start, found = resolveQuote(a.RawText, h.Selector)
+		}
+		h.Orphaned = !found
+		if found {
+			h.Selector.Start = start
+		}
+	}
+}
+
+func highlightsList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	q := bson.M{"user": user.Id}
+	if id := req.URL.Query().Get("article"); id != "" {
+		if !bson.IsObjectIdHex(id) {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		q["article"] = bson.ObjectIdHex(id)
+	}
+	list := []Highlight{}
+	err = ds.C("Highlights").Find(q).Sort("-created").All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find highlights")
+		return
+	}
+	anchorHighlights(ds, list)
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+func highlightAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := req.FormValue("article")
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	var a Article
+	err = ds.C("Articles").FindId(bson.ObjectIdHex(id)).Select(bson.M{"text": 1, "RowText": 1}).One(&a)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+	start, _ := strconv.Atoi(req.FormValue("start"))
+	h := Highlight{
+		Id:      bson.NewObjectId(),
+		User:    user.Id,
+		Article: a.Id,
+		Selector: QuoteSelector{
+			Exact:  normalizeSpace(req.FormValue("exact")),
+			Prefix: tail(collapseSpace(req.FormValue("prefix"))),
+			Suffix: head(collapseSpace(req.FormValue("suffix"))),
+			Start:  start,
+		},
+		Note:    strings.TrimSpace(req.FormValue("note")),
+		Created: time.Now(),
+	}
+	h.Updated = h.Created
+	if h.Selector.Exact == "" && h.Note == "" {
+		respondWithError(w, http.StatusBadRequest, "Highlight is empty")
+		return
+	}
+	if utf8.RuneCountInString(h.Selector.Exact) > maxQuote || utf8.RuneCountInString(h.Note) > maxNote {
+		respondWithError(w, http.StatusBadRequest, "Highlight is too long")
+		return
+	}
+	if h.Selector.Exact != "" {
+		start, found := resolveQuote(a.Text, h.Selector)
+		if !found {
+			start, found = resolveQuote(a.RawText, h.Selector)
+		}
+		if !found {
+			respondWithError(w, http.StatusBadRequest, "Quote not found in the article")
+			return
+		}
+		h.Selector.Start = start
+	}
+	err = ds.C("Highlights").Insert(h)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save highlight")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, h)
+}
+
+// highlightChange edits the note of a highlight
+func highlightChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	note := strings.TrimSpace(req.FormValue("note"))
+	if utf8.RuneCountInString(note) > maxNote {
+		respondWithError(w, http.StatusBadRequest, "Note is too long")
+		return
+	}
+	err = ds.C("Highlights").Update(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id},
+		bson.M{"$set": bson.M{"note": note, "updated": time.Now()}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't update highlight")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+func highlightDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Highlights").Remove(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete highlight")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// highlightsExport writes all highlights of the user as Markdown,
+// grouped by article in the order of the latest highlight
+func highlightsExport(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var list []Highlight
+	err = ds.C("Highlights").Find(bson.M{"user": user.Id}).Sort("-created").All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find highlights")
+		return
+	}
+	anchorHighlights(ds, list)
+
+	var order []bson.ObjectId
+	byArticle := make(map[bson.ObjectId][]Highlight)
+	for _, h := range list {
+		if _, ok := byArticle[h.Article]; !ok {
+			order = append(order, h.Article)
+		}
+		byArticle[h.Article] = append(byArticle[h.Article], h)
+	}
+	var b bytes.Buffer
+	b.WriteString("# Мои выделения\n")
+	for _, id := range order {
+		hs := byArticle[id]
+		title := hs[0].Title
+		if title == "" {
+			title = "Удаленная статья"
+		}
+		if hs[0].Link != "" {
+			fmt.Fprintf(&b, "\n## [%s](%s)\n", markdownEscape(title), hs[0].Link)
+		} else {
+			fmt.Fprintf(&b, "\n## %s\n", markdownEscape(title))
+		}
+		for _, h := range hs {
+			b.WriteString("\n")
+			if h.Selector.Exact != "" {
+				fmt.Fprintf(&b, "> %s\n", h.Selector.Exact)
+				if h.Note != "" {
+					b.WriteString("\n")
+				}
+			}
+			if h.Note != "" {
+				fmt.Fprintf(&b, "%s\n", h.Note)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="highlights.md"`)
+	w.Write(b.Bytes())
+}
+
+var markdownEscaper = strings.NewReplacer(`[`, `\[`, `]`, `\]`, `*`, `\*`, `_`, `\_`, "`", "\\`")
+
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
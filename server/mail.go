@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Mail struct {
+	To          string
+	Subject     string
+	Text        string
+	HTML        string
+	Unsubscribe string
+}
+
+// Mailer delivers rendered letters
+type Mailer interface {
+	Send(m Mail) error
+}
+
+// smtpMailer sends letters through an SMTP relay without auth
+type smtpMailer struct {
+	addr string
+	from string
+}
+
+func (s smtpMailer) Send(m Mail) error {
+	msg, err := buildMessage(s.from, m)
+	if err != nil {
+		return err
+	}
+	// the envelope takes the bare address of "Name <address>"
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, nil, from.Address, []string{m.To}, msg)
+}
+
+// fileMailer writes letters as .eml files, useful for development
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (f fileMailer) Send(m Mail) error {
+	msg, err := buildMessage(f.from, m)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(f.dir, 0755)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.Replace(m.To, "@", "_", -1))
+	return ioutil.WriteFile(filepath.Join(f.dir, name), msg, 0644)
+}
+
+// newMailer picks the mailer by MAILER env: smtp or file (default)
+func newMailer() Mailer {
+	from := getEnv("MAIL_FROM", "NeFeed <noreply@nefeed.ga>")
+	if getEnv("MAILER", "file") == "smtp" {
+		return smtpMailer{addr: getEnv("SMTP_ADDR", "localhost:25"), from: from}
+	}
+	return fileMailer{dir: getEnv("MAIL_DIR", "mail"), from: from}
+}
+
+func buildMessage(from string, m Mail) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if m.Unsubscribe != "" {
+		fmt.Fprintf(&buf, "List-Unsubscribe: <%s>\r\n", m.Unsubscribe)
+		fmt.Fprintf(&buf, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+
+	for _, part := range []struct{ typ, body string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		if part.body == "" {
+			continue
+		}
+		p, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.Write([]byte(part.body))
+	}
+	err := w.Close()
+	return buf.Bytes(), err
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -18,26 +18,50 @@ import (
 )
 
 type User struct {
-	Id          bson.ObjectId   `bson:"_id,omitempty"`
-	Email       string          `bson:"email"`
-	Password    string          `bson:"password"`
-	Age         string          `bson:"age"`
-	Gender      string          `bson:"gender"`
-	Tags        []string        `bson:"tags"`
-	Feed        []bson.ObjectId `bson:"feed"`
-	LikeNews    []bson.ObjectId `bson:"likeNews"`
-	DislikeNews []bson.ObjectId `bson:"dislikeNews"`
+	Id             bson.ObjectId     `bson:"_id,omitempty"`
+	Email          string            `bson:"email"`
+	Password       string            `bson:"password"`
+	Age            string            `bson:"age"`
+	Gender         string            `bson:"gender"`
+	Tags           []string          `bson:"tags"`
+	Feed           []bson.ObjectId   `bson:"feed"`
+	LikeNews       []bson.ObjectId   `bson:"likeNews"`
+	DislikeNews    []bson.ObjectId   `bson:"dislikeNews"`
+	Mutes          []MuteRule        `bson:"mutes"`
+	Digest         DigestSettings    `bson:"digest"`
+	Bookmarks      []bson.ObjectId   `bson:"bookmarks"`
+	Admin          bool              `bson:"admin"`
+	Moderator      bool              `bson:"moderator"`
+	NoReadTracking bool              `bson:"noReadTracking"`
+	Diversity      DiversitySettings `bson:"diversity"`
+	Pins           []bson.ObjectId   `bson:"pins"`
+	Snoozes        []Snooze          `bson:"snoozes"`
+	PublicProfile  bool              `bson:"publicProfile"`
+	ProfileName    string            `bson:"profileName"`
+	Following      []bson.ObjectId   `bson:"following"`
 }
 
 type UserPublic struct {
-	Id          bson.ObjectId   `bson:"_id,omitempty"`
-	Email       string          `bson:"email"`
-	Tags        []string        `bson:"tags"`
-	Age         string          `bson:"age"`
-	Gender      string          `bson:"gender"`
-	Feed        []bson.ObjectId `bson:"feed"`
-	LikeNews    []bson.ObjectId `bson:"likeNews"`
-	DislikeNews []bson.ObjectId `bson:"dislikeNews"`
+	Id             bson.ObjectId     `bson:"_id,omitempty"`
+	Email          string            `bson:"email"`
+	Tags           []string          `bson:"tags"`
+	Age            string            `bson:"age"`
+	Gender         string            `bson:"gender"`
+	Feed           []bson.ObjectId   `bson:"feed"`
+	LikeNews       []bson.ObjectId   `bson:"likeNews"`
+	DislikeNews    []bson.ObjectId   `bson:"dislikeNews"`
+	Mutes          []MuteRule        `bson:"mutes"`
+	Digest         DigestSettings    `bson:"digest"`
+	Bookmarks      []bson.ObjectId   `bson:"bookmarks"`
+	Admin          bool              `bson:"admin"`
+	Moderator      bool              `bson:"moderator"`
+	NoReadTracking bool              `bson:"noReadTracking"`
+	Diversity      DiversitySettings `bson:"diversity"`
+	Pins           []bson.ObjectId   `bson:"pins"`
+	Snoozes        []Snooze          `bson:"snoozes"`
+	PublicProfile  bool              `bson:"publicProfile"`
+	ProfileName    string            `bson:"profileName"`
+	Following      []bson.ObjectId   `bson:"following"`
 }
 
 type Article struct {
@@ -53,6 +77,12 @@ type Article struct {
 	NumLinks  int           `bson:"numLinks"`
 	NumImg    int           `bson:"numImg"`
 	Timestamp time.Time     `bson:"timestamp"`
+	Related   []Related     `bson:"related"`
+	AutoTags  []AutoTag     `bson:"autoTags"`
+	Keywords  []Keyword     `bson:"keywords"`
+	Entities  []Entity      `bson:"entities"`
+	Summary   string        `bson:"summary"`
+	Stats     *ArticleStats `bson:"-"`
 }
 
 type ArticleFeed struct {
@@ -61,9 +91,16 @@ type ArticleFeed struct {
 	Checked   bool
 	Link      string `bson:"link"`
 	TopImage  string
-	Source    string    `bson:"source"`
-	Text      string    `bson:"text"`
-	Timestamp time.Time `bson:"timestamp"`
+	Source    string       `bson:"source"`
+	Summary   string       `bson:"summary"`
+	Timestamp time.Time    `bson:"timestamp"`
+	Muted     *MuteRule    `bson:"-"`
+	Reasons   []Reason     `bson:"-"`
+	Pinned    bool         `bson:"-"`
+	Snoozed   bool         `bson:"-"`
+	Team      []TeamRating `bson:"-"`
+	Comments  int          `bson:"-"`
+	AutoTags  []AutoTag    `bson:"autoTags"`
 }
 
 type Token struct {
@@ -134,20 +171,119 @@ func main() {
 		log.Print(err)
 		time.Sleep(time.Second * 5)
 	}
+	backfillStats()
+	backfillEvents()
+	go digestScheduler(newMailer())
+	go consumeArticles()
+	go trendingScheduler()
+	go seedTags()
+	go ensureEntityIndex()
+
 	router := mux.NewRouter()
 	router.HandleFunc("/login", login).Methods("POST")
 	router.HandleFunc("/signup", signup).Methods("POST")
 	router.HandleFunc("/ratelike/{id}", restrictedHandler(rateLike)).Methods("POST")
 	router.HandleFunc("/ratedislike/{id}", restrictedHandler(rateDislike)).Methods("POST")
+	router.HandleFunc("/rateunrate/{id}", restrictedHandler(rateUnrate)).Methods("POST")
 	router.HandleFunc("/feed/{page:[0-9]+}", restrictedHandler(feed)).Methods("GET")
 	router.HandleFunc("/article/{id}", restrictedHandler(article)).Methods("GET")
+	router.HandleFunc("/article/{id}/related", restrictedHandler(articleRelated)).Methods("GET")
+	router.HandleFunc("/go/{id}", restrictedHandler(goLink)).Methods("GET")
+	router.HandleFunc("/article/{id}/share", restrictedHandler(shareAdd)).Methods("POST")
+	router.HandleFunc("/article/{id}/link", restrictedHandler(shareLinkAdd)).Methods("POST")
+	router.HandleFunc("/article/{id}/comments", restrictedHandler(commentsList)).Methods("GET")
+	router.HandleFunc("/article/{id}/comments", restrictedHandler(commentAdd)).Methods("POST")
+	router.HandleFunc("/comments/{id}", restrictedHandler(commentChange)).Methods("POST")
+	router.HandleFunc("/comments/{id}", restrictedHandler(commentDelete)).Methods("DELETE")
+	router.HandleFunc("/comments/{id}/report", restrictedHandler(commentReport)).Methods("POST")
+	router.HandleFunc("/moderation/comments", moderatorHandler(moderationQueue)).Methods("GET")
+	router.HandleFunc("/moderation/comments/{id}", moderatorHandler(moderationDecide)).Methods("POST")
+	router.HandleFunc("/inbox", restrictedHandler(inboxList)).Methods("GET")
+	router.HandleFunc("/inbox/{id}", restrictedHandler(inboxDelete)).Methods("DELETE")
+	router.HandleFunc("/links", restrictedHandler(shareLinksList)).Methods("GET")
+	router.HandleFunc("/links/{token}", restrictedHandler(shareLinkDelete)).Methods("DELETE")
+	router.HandleFunc("/links/{token}/article", sharedArticle).Methods("GET")
+	router.HandleFunc("/article/{id}/read", restrictedHandler(articleRead)).Methods("POST")
 	router.HandleFunc("/todayfeed", restrictedHandler(toDayFeed)).Methods("GET")
+	router.HandleFunc("/stream", restrictedHandler(stream)).Methods("GET")
+	router.HandleFunc("/trending", restrictedHandler(trending)).Methods("GET")
+	router.HandleFunc("/bookmarks", restrictedHandler(bookmarks)).Methods("GET")
+	router.HandleFunc("/bookmark/{id}", restrictedHandler(bookmarkAdd)).Methods("POST")
+	router.HandleFunc("/bookmark/{id}", restrictedHandler(bookmarkDelete)).Methods("DELETE")
+	router.HandleFunc("/pin/{id}", restrictedHandler(pinAdd)).Methods("POST")
+	router.HandleFunc("/pin/{id}", restrictedHandler(pinDelete)).Methods("DELETE")
+	router.HandleFunc("/snooze/{id}", restrictedHandler(snoozeAdd)).Methods("POST")
+	router.HandleFunc("/snooze/{id}", restrictedHandler(snoozeDelete)).Methods("DELETE")
+	router.HandleFunc("/highlights", restrictedHandler(highlightsList)).Methods("GET")
+	router.HandleFunc("/highlights", restrictedHandler(highlightAdd)).Methods("POST")
+	router.HandleFunc("/highlights/export", restrictedHandler(highlightsExport)).Methods("GET")
+	router.HandleFunc("/highlights/{id}", restrictedHandler(highlightChange)).Methods("POST")
+	router.HandleFunc("/highlights/{id}", restrictedHandler(highlightDelete)).Methods("DELETE")
+	router.HandleFunc("/collections", restrictedHandler(collectionsList)).Methods("GET")
+	router.HandleFunc("/collections", restrictedHandler(collectionAdd)).Methods("POST")
+	router.HandleFunc("/collections/public", publicCollections).Methods("GET")
+	router.HandleFunc("/collections/shared/{token}", sharedCollection).Methods("GET")
+	router.HandleFunc("/collections/{id}", restrictedHandler(collectionGet)).Methods("GET")
+	router.HandleFunc("/collections/{id}", restrictedHandler(collectionChange)).Methods("POST")
+	router.HandleFunc("/collections/{id}", restrictedHandler(collectionDelete)).Methods("DELETE")
+	router.HandleFunc("/collections/{id}/articles", restrictedHandler(collectionItemAdd)).Methods("POST")
+	router.HandleFunc("/collections/{id}/articles/{article}", restrictedHandler(collectionItemDelete)).Methods("DELETE")
+	router.HandleFunc("/collections/{id}/order", restrictedHandler(collectionOrder)).Methods("POST")
+	router.HandleFunc("/workspaces", restrictedHandler(workspacesList)).Methods("GET")
+	router.HandleFunc("/workspaces", restrictedHandler(workspaceAdd)).Methods("POST")
+	router.HandleFunc("/invitations", restrictedHandler(invitationsList)).Methods("GET")
+	router.HandleFunc("/invitations/{token}/accept", restrictedHandler(invitationAccept)).Methods("POST")
+	router.HandleFunc("/workspaces/{id}", restrictedHandler(workspaceGet)).Methods("GET")
+	router.HandleFunc("/workspaces/{id}", restrictedHandler(workspaceChange)).Methods("POST")
+	router.HandleFunc("/workspaces/{id}", restrictedHandler(workspaceDelete)).Methods("DELETE")
+	router.HandleFunc("/workspaces/{id}/feed/{page:[0-9]+}", restrictedHandler(workspaceFeed)).Methods("GET")
+	router.HandleFunc("/workspaces/{id}/invitations", restrictedHandler(workspaceInvite)).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/members/{user}", restrictedHandler(memberDelete)).Methods("DELETE")
+	router.HandleFunc("/workspaces/{id}/members/{user}/role", restrictedHandler(memberRole)).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/mustread", restrictedHandler(mustReadAdd)).Methods("POST")
+	router.HandleFunc("/workspaces/{id}/mustread/{article}", restrictedHandler(mustReadDelete)).Methods("DELETE")
+	router.HandleFunc("/admin/sources", adminHandler(adminSources)).Methods("GET")
+	router.HandleFunc("/tags", tagsList).Methods("GET")
+	router.HandleFunc("/article/{id}/autotags", restrictedHandler(autoTagFeedback)).Methods("POST")
+	router.HandleFunc("/admin/tags", adminHandler(tagSave)).Methods("POST")
+	router.HandleFunc("/admin/tags/{slug}/rename", adminHandler(tagRename)).Methods("POST")
+	router.HandleFunc("/admin/tags/{slug}/merge", adminHandler(tagMerge)).Methods("POST")
+	router.HandleFunc("/entities", restrictedHandler(entitiesList)).Methods("GET")
+	router.HandleFunc("/entities/{key}", restrictedHandler(entityGet)).Methods("GET")
+	router.HandleFunc("/entities/{key}/feed/{page:[0-9]+}", restrictedHandler(entityFeed)).Methods("GET")
+	router.HandleFunc("/entities/{key}/follow", restrictedHandler(entityFollow)).Methods("POST")
+	router.HandleFunc("/entities/{key}/follow", restrictedHandler(entityUnfollow)).Methods("DELETE")
 	router.HandleFunc("/account", restrictedHandler(accountData)).Methods("GET")
 	router.HandleFunc("/account/chenge/tags", restrictedHandler(accountTagsChange)).Methods("GET")
+	router.HandleFunc("/account/tracking", restrictedHandler(accountTrackingChange)).Methods("POST")
+	router.HandleFunc("/account/profile", restrictedHandler(accountProfileChange)).Methods("POST")
+	router.HandleFunc("/profiles", restrictedHandler(profilesList)).Methods("GET")
+	router.HandleFunc("/profiles/{id}", restrictedHandler(profileGet)).Methods("GET")
+	router.HandleFunc("/profiles/{id}/follow", restrictedHandler(follow)).Methods("POST")
+	router.HandleFunc("/profiles/{id}/follow", restrictedHandler(unfollow)).Methods("DELETE")
+	router.HandleFunc("/diversity", restrictedHandler(diversitySettings)).Methods("GET")
+	router.HandleFunc("/diversity", restrictedHandler(diversitySettingsChange)).Methods("POST")
+	router.HandleFunc("/mutes", restrictedHandler(mutesList)).Methods("GET")
+	router.HandleFunc("/mutes", restrictedHandler(muteAdd)).Methods("POST")
+	router.HandleFunc("/mutes/{id}", restrictedHandler(muteDelete)).Methods("DELETE")
+	router.HandleFunc("/searches", restrictedHandler(searchesList)).Methods("GET")
+	router.HandleFunc("/searches", restrictedHandler(searchAdd)).Methods("POST")
+	router.HandleFunc("/searches/{id}", restrictedHandler(searchDelete)).Methods("DELETE")
+	router.HandleFunc("/searches/{id}/feed/{page:[0-9]+}", restrictedHandler(searchFeed)).Methods("GET")
+	router.HandleFunc("/notifications", restrictedHandler(notificationsList)).Methods("GET")
+	router.HandleFunc("/notifications/read", restrictedHandler(notificationsRead)).Methods("POST")
+	router.HandleFunc("/digest", restrictedHandler(digestSettings)).Methods("GET")
+	router.HandleFunc("/digest", restrictedHandler(digestSettingsChange)).Methods("POST")
+	router.HandleFunc("/digest/unsubscribe/{token}", digestUnsubscribe).Methods("GET", "POST")
+	router.HandleFunc("/webhooks", restrictedHandler(webhooksList)).Methods("GET")
+	router.HandleFunc("/webhooks", restrictedHandler(webhookAdd)).Methods("POST")
+	router.HandleFunc("/webhooks/{id}", restrictedHandler(webhookDelete)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/enable", restrictedHandler(webhookEnable)).Methods("POST")
+	router.HandleFunc("/webhooks/{id}/deliveries", restrictedHandler(webhookDeliveries)).Methods("GET")
 
 	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With"})
 	originsOk := handlers.AllowedOrigins([]string{"*"})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"})
 	log.Fatal(http.ListenAndServe(":12345", handlers.CORS(originsOk, headersOk, methodsOk)(router)))
 }
 
@@ -205,7 +341,7 @@ func signup(w http.ResponseWriter, req *http.Request) {
 	}
 	password := req.FormValue("password")
 	email := strings.ToLower(req.FormValue("email"))
-	tags := req.Form["tags"]
+	tags := canonicalTags(ds, req.Form["tags"])
 	age := req.Form["age"]
 	gender := req.Form["gender"]
 
@@ -303,13 +439,21 @@ func rateLike(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Can't find user")
 		return
 	}
+	if !bson.IsObjectIdHex(id["id"]) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	articleId := bson.ObjectIdHex(id["id"])
 	for _, i := range user.DislikeNews {
-		if bson.ObjectIdHex(id["id"]) == i {
+		if articleId == i {
 			return
 		}
 	}
-	err = c.Update(bson.M{"_id": user.Id}, bson.M{"$addToSet": bson.M{"likeNews": bson.ObjectIdHex(id["id"])}})
-	if err != nil {
+	err = c.Update(bson.M{"_id": user.Id, "likeNews": bson.M{"$ne": articleId}}, bson.M{"$push": bson.M{"likeNews": articleId}})
+	if err == nil {
+		countEngagement(ds, articleId, "likes", 1)
+		recordEvent(ds, req, user, articleId, actionLike)
+	} else if err != mgo.ErrNotFound {
 		respondWithError(w, http.StatusBadRequest, "Can't add this article")
 		return
 	}
@@ -332,13 +476,21 @@ func rateDislike(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Can't find user")
 		return
 	}
+	if !bson.IsObjectIdHex(id["id"]) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	articleId := bson.ObjectIdHex(id["id"])
 	for _, i := range user.LikeNews {
-		if bson.ObjectIdHex(id["id"]) == i {
+		if articleId == i {
 			return
 		}
 	}
-	err = c.Update(bson.M{"_id": user.Id}, bson.M{"$addToSet": bson.M{"dislikeNews": bson.ObjectIdHex(id["id"])}})
-	if err != nil {
+	err = c.Update(bson.M{"_id": user.Id, "dislikeNews": bson.M{"$ne": articleId}}, bson.M{"$push": bson.M{"dislikeNews": articleId}})
+	if err == nil {
+		countEngagement(ds, articleId, "dislikes", 1)
+		recordEvent(ds, req, user, articleId, actionDislike)
+	} else if err != mgo.ErrNotFound {
 		respondWithError(w, http.StatusBadRequest, "Can't add this article")
 		return
 	}
@@ -346,28 +498,19 @@ func rateDislike(w http.ResponseWriter, req *http.Request) {
 }
 
 func feed(w http.ResponseWriter, req *http.Request) {
-	tokenHeader := req.Header.Get("auth")
-
 	var (
-		user     User
 		f        []ArticleFeed
-		slice    [2]int
 		userFeed []Article
 	)
 
 	// connect to db
 	ds := NewDataStore()
 	defer ds.Close()
-	c := ds.C("Users")
-	ca := ds.C("Articles")
 
 	page := mux.Vars(req)
 
 	// take user data
-	token, _ := jwt.ParseWithClaims(tokenHeader, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return verifyKey, nil
-	})
-	err := c.Find(bson.M{"email": token.Claims.(*Claims).Email}).One(&user)
+	user, err := currentUser(ds, req)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Can't find user")
 		return
@@ -376,48 +519,112 @@ func feed(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println(err)
 		respondWithError(w, http.StatusBadRequest, "Can't find this page")
+		return
 	}
+	showMuted := req.URL.Query().Get("muted") == "1"
+	mode := req.URL.Query().Get("mode")
 
-	if pageInt == 0 {
-		slice[0] = 0
-		slice[1] = 10
-	} else {
-		slice[0] = 0 + 10*(pageInt)
-		slice[1] = 10 + 10*(pageInt)
+	mutes := compileMutes(user.Mutes)
+	top, pinned, woken, hidden := feedPins(user, time.Now())
+	keep := func(a Article) bool {
+		if pinned[a.Id] || woken[a.Id] || hidden[a.Id] {
+			return false
+		}
+		return showMuted || mutedBy(mutes, a) == nil
+	}
+	userFeed, err = modeArticles(ds, user, mode, req.URL.Query().Get("period"), keep, rerankWindow(10*(pageInt+1)))
+	if err == errUnknownMode || err == errUnknownPeriod {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
 	}
 
-	err = ca.Find(bson.M{"tags": bson.M{"$in": user.Tags}}).Sort("-timestamp").All(&userFeed)
-	reFeed := userFeed[slice[0]:slice[1]]
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	r := newReasoner(ds, user)
+	userFeed = append(findArticles(ds, top), userFeed...)
+	userFeed, sharedBy := sharedArticles(ds, user, userFeed)
+
+	for _, article := range userFeed {
+		rule := mutedBy(mutes, article)
+		if rule != nil && !showMuted {
+			continue
+		}
+		a := newArticleFeed(article, checked)
+		a.Muted = rule
+		a.Pinned = pinned[article.Id]
+		a.Snoozed = woken[article.Id]
+		a.Reasons = r.reasons(article)
+		if len(a.Reasons) == 0 && mode == feedDiscovery {
+			a.Reasons = []Reason{{Kind: reasonDiscovery}}
+		}
+		if from := sharedBy[article.Id]; from != "" {
+			a.Reasons = append([]Reason{{Kind: reasonShared, Value: from}}, a.Reasons...)
+		}
+		f = append(f, a)
+	}
+
+	writeFeedPage(w, ds, user, f, pageInt)
+}
 
-	if len(user.Feed) < slice[1] {
-		slice[1] = len(user.Feed)
+// writeFeedPage responds with page of f, ten articles per page, and
+// the number of the last page in the npage header
+func writeFeedPage(w http.ResponseWriter, ds *DataStore, user User, f []ArticleFeed, page int) {
+	from, to, nPage := feedPage(len(f), page)
+	writePage(w, ds, user, f[from:to], nPage)
+}
+
+// writeArticlePage is writeFeedPage for the feeds which are read from
+// the database in order, muted articles are left out unless showMuted
+// and only the articles of the page become feed entries
+func writeArticlePage(w http.ResponseWriter, ds *DataStore, user User, articles []Article, page int, showMuted bool) {
+	mutes := compileMutes(user.Mutes)
+	if !showMuted {
+		articles = keepArticles(articles, func(a Article) bool { return mutedBy(mutes, a) == nil })
 	}
+	from, to, nPage := feedPage(len(articles), page)
+
 	var checked []bson.ObjectId
 	checked = append(checked, user.LikeNews...)
 	checked = append(checked, user.DislikeNews...)
+	r := newReasoner(ds, user)
+	f := []ArticleFeed{}
+	for _, article := range articles[from:to] {
+		a := newArticleFeed(article, checked)
+		a.Muted = mutedBy(mutes, article)
+		a.Reasons = r.reasons(article)
+		f = append(f, a)
+	}
+	writePage(w, ds, user, f, nPage)
+}
 
-	for _, article := range reFeed[slice[0]:slice[1]] {
-		ch := false
-		for _, i := range checked {
-			if i == article.Id {
-				ch = true
-				f = append(f, ArticleFeed{article.Id, article.Title, true, article.Link, article.TopImage, article.Source,
-					article.Text, article.Timestamp})
-				break
-			}
-		}
-		if ch != true {
-			f = append(f, ArticleFeed{article.Id, article.Title, false, article.Link, article.TopImage, article.Source,
-				article.Text, article.Timestamp})
-		}
+// feedPage returns the bounds of page among n articles and the number
+// of the last page
+func feedPage(n, page int) (from, to, nPage int) {
+	from, to = 10*page, 10+10*page
+	if to > n {
+		to = n
+	}
+	if from > to {
+		from = to
+	}
+	if n > 0 {
+		nPage = (n - 1) / 10
 	}
+	return from, to, nPage
+}
+
+func writePage(w http.ResponseWriter, ds *DataStore, user User, f []ArticleFeed, nPage int) {
+	commentCounts(ds, user, f)
 	response, err := json.Marshal(f)
 	if err != nil {
 		log.Println("json marshal: ", err)
 	}
 	w.Header().Set("Content-Type", "application/json")
-	// nPage = len(user.Feed) / 10
-	// w.Header().Set("npage", strconv.Itoa(nPage))
+	w.Header().Set("npage", strconv.Itoa(nPage))
 	w.Write(response)
 }
 
@@ -440,14 +647,77 @@ func article(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Can't find user")
 		return
 	}
+	if !bson.IsObjectIdHex(id["id"]) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
 	var art Article
 	err = ca.FindId(bson.ObjectIdHex(id["id"])).One(&art)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
 	}
+	countOpen(ds, req, user, art.Id)
+	markSharesRead(ds, user, art.Id)
+	stats := articleStats(ds, art.Id)
+	art.Stats = &stats
 	respondWithJSON(w, http.StatusOK, art)
 }
 
+// currentUser loads the owner of the auth token
+func currentUser(ds *DataStore, req *http.Request) (User, error) {
+	var user User
+	token, err := jwt.ParseWithClaims(req.Header.Get("auth"), &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		return verifyKey, nil
+	})
+	if err != nil {
+		return user, err
+	}
+	err = ds.C("Users").Find(bson.M{"email": token.Claims.(*Claims).Email}).One(&user)
+	return user, err
+}
+
+// newArticleFeed makes a feed item, checked is the list of rated articles
+func newArticleFeed(article Article, checked []bson.ObjectId) ArticleFeed {
+	a := ArticleFeed{
+		Id:        article.Id,
+		Title:     article.Title,
+		Link:      article.Link,
+		TopImage:  article.TopImage,
+		Source:    article.Source,
+		Summary:   article.Summary,
+		Timestamp: article.Timestamp,
+		AutoTags:  article.AutoTags,
+	}
+	if a.Summary == "" {
+		a.Summary = shortText(article.Text, feedSummaryLen)
+	}
+	for _, i := range checked {
+		if i == article.Id {
+			a.Checked = true
+			break
+		}
+	}
+	return a
+}
+
+// feedSummaryLen is the length of the text shown instead of the summary
+// of an article which articaleServer has not summarized yet
+const feedSummaryLen = 300
+
+// shortText cuts the text to n runes at a space
+func shortText(text string, n int) string {
+	r := []rune(strings.Join(strings.Fields(text), " "))
+	if len(r) <= n {
+		return string(r)
+	}
+	s := string(r[:n])
+	if i := strings.LastIndex(s, " "); i > 0 {
+		s = s[:i]
+	}
+	return s + "…"
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
@@ -478,62 +748,38 @@ func accountData(w http.ResponseWriter, req *http.Request) {
 }
 
 func toDayFeed(w http.ResponseWriter, req *http.Request) {
-	tokenHeader := req.Header.Get("auth")
-	var (
-		user User
-		f    []ArticleFeed
-	)
+	var f []ArticleFeed
 
 	ds := NewDataStore()
 	defer ds.Close()
-	c := ds.C("Users")
-	ca := ds.C("Articles")
 
-	token, _ := jwt.ParseWithClaims(tokenHeader, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return verifyKey, nil
-	})
-	err := c.Find(bson.M{"email": token.Claims.(*Claims).Email}).One(&user)
+	user, err := currentUser(ds, req)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Can't find user")
 		return
 	}
+	showMuted := req.URL.Query().Get("muted") == "1"
 
-	// revers feed ids
-	var reFeed []bson.ObjectId
-	for i := len(user.Feed) - 1; i >= 0; i-- {
-		reFeed = append(reFeed, user.Feed[i])
-	}
 	var checked []bson.ObjectId
-	for _, i := range user.LikeNews {
-		checked = append(checked, i)
-	}
-	for _, i := range user.DislikeNews {
-		checked = append(checked, i)
-	}
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	mutes := compileMutes(user.Mutes)
+	r := newReasoner(ds, user)
+
 	loc, _ := time.LoadLocation("Europe/Moscow")
 	date := time.Now().In(loc).Add(-24 * time.Hour)
-	for _, a := range reFeed {
-		var article Article
-		err = ca.FindId(a).One(&article)
-		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "Can't find any of article")
-		}
-		ch := false
-		for _, i := range checked {
-			if i == article.Id {
-				ch = true
-				f = append(f, ArticleFeed{article.Id, article.Title, true, article.Link, article.TopImage, article.Source,
-					article.Text, article.Timestamp})
-				break
-			}
-		}
-		if ch != true {
-			f = append(f, ArticleFeed{article.Id, article.Title, false, article.Link, article.TopImage, article.Source,
-				article.Text, article.Timestamp})
-		}
-		if article.Timestamp.Before(date) {
-			break
+	articles, pinned, woken := pinsAndSnoozes(ds, user, feedSince(ds, user, date), time.Now())
+	for _, article := range articles {
+		rule := mutedBy(mutes, article)
+		if rule != nil && !showMuted {
+			continue
 		}
+		af := newArticleFeed(article, checked)
+		af.Muted = rule
+		af.Pinned = pinned[article.Id]
+		af.Snoozed = woken[article.Id]
+		af.Reasons = r.reasons(article)
+		f = append(f, af)
 	}
 	response, err := json.Marshal(f)
 	if err != nil {
@@ -544,6 +790,24 @@ func toDayFeed(w http.ResponseWriter, req *http.Request) {
 	w.Write(response)
 }
 
+// feedSince returns articles of user.Feed newer than since, newest first
+func feedSince(ds *DataStore, user User, since time.Time) []Article {
+	var articles []Article
+	ca := ds.C("Articles")
+	for i := len(user.Feed) - 1; i >= 0; i-- {
+		var article Article
+		err := ca.FindId(user.Feed[i]).One(&article)
+		if err != nil {
+			continue
+		}
+		if article.Timestamp.Before(since) {
+			break
+		}
+		articles = append(articles, article)
+	}
+	return articles
+}
+
 func accountTagsChange(w http.ResponseWriter, req *http.Request) {
 	tokenHeader := req.Header.Get("auth")
 	ds := NewDataStore()
@@ -563,7 +827,7 @@ func accountTagsChange(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println(err)
 	}
-	tags := req.Form["tags"]
+	tags := canonicalTags(ds, req.Form["tags"])
 	c.Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"tags": tags}})
 }
 
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// modes of /feed
+const (
+	feedLatest      = "latest"
+	feedTop         = "top"
+	feedRecommended = "recommended"
+	feedDiscovery   = "discovery"
+	feedFollowing   = "following"
+)
+
+var feedPeriods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+const (
+	// recommended ranks recent articles only
+	recommendWindow = 14 * 24 * time.Hour
+	recommendDecay  = 48 * time.Hour
+	// every discoveryEvery-th article of discovery is from other tags
+	discoveryEvery  = 3
+	discoveryWindow = 7 * 24 * time.Hour
+)
+
+var (
+	errUnknownMode   = errors.New("Unknown feed mode")
+	errUnknownPeriod = errors.New("Unknown period")
+)
+
+// modeArticles returns the articles of the user's feed in mode, before
+// pagination is applied. Articles rejected by keep, which is where the
+// caller drops muted, snoozed and pinned ones, are dropped before the
+// re-ranking, so hiding them can't join two runs of a source. Only the
+// first window articles are re-ranked for diversity, see rerankWindow.
+func modeArticles(ds *DataStore, user User, mode, period string, keep func(Article) bool, window int) ([]Article, error) {
+	switch mode {
+	case "", feedLatest:
+		return latestArticles(ds, user, keep, window)
+	case feedTop:
+		d, ok := feedPeriods[period]
+		if period == "" {
+			d, ok = feedPeriods["week"], true
+		}
+		if !ok {
+			return nil, errUnknownPeriod
+		}
+		articles, err := topArticles(ds, user, d)
+		return keepArticles(articles, keep), err
+	case feedRecommended:
+		return recommendedArticles(ds, user, keep, window)
+	case feedDiscovery:
+		return discoveryArticles(ds, user, keep, window)
+	case feedFollowing:
+		articles, err := followingArticles(ds, user)
+		return keepArticles(articles, keep), err
+	}
+	return nil, errUnknownMode
+}
+
+// keepArticles drops the articles keep rejects, a nil keep keeps all
+func keepArticles(articles []Article, keep func(Article) bool) []Article {
+	if keep == nil {
+		return articles
+	}
+	res := articles[:0]
+	for _, a := range articles {
+		if keep(a) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func latestArticles(ds *DataStore, user User, keep func(Article) bool, window int) ([]Article, error) {
+	var articles []Article
+	err := ds.C("Articles").Find(bson.M{"tags": bson.M{"$in": user.Tags}}).Sort("-timestamp").All(&articles)
+	if err != nil {
+		return nil, err
+	}
+	return diversify(ds, user, keepArticles(articles, keep), window), nil
+}
+
+// engagementScore ranks by how much readers cared about an article
+func engagementScore(s ArticleStats) float64 {
+	return float64(s.Likes-s.Dislikes) + 0.5*float64(s.Bookmarks) + 0.1*float64(s.Opens)
+}
+
+func topArticles(ds *DataStore, user User, period time.Duration) ([]Article, error) {
+	var articles []Article
+	err := ds.C("Articles").Find(bson.M{"tags": bson.M{"$in": user.Tags}, "timestamp": bson.M{"$gte": time.Now().Add(-period)}}).
+		All(&articles)
+	if err != nil {
+		return nil, err
+	}
+	scores := statsScores(ds, articles)
+	sort.SliceStable(articles, func(i, j int) bool {
+		if scores[articles[i].Id] != scores[articles[j].Id] {
+			return scores[articles[i].Id] > scores[articles[j].Id]
+		}
+		return articles[i].Timestamp.After(articles[j].Timestamp)
+	})
+	return articles, nil
+}
+
+func statsScores(ds *DataStore, articles []Article) map[bson.ObjectId]float64 {
+	ids := make([]bson.ObjectId, len(articles))
+	for i, a := range articles {
+		ids[i] = a.Id
+	}
+	var stats []ArticleStats
+	ds.C("ArticleStats").Find(bson.M{"_id": bson.M{"$in": ids}}).All(&stats)
+	scores := make(map[bson.ObjectId]float64)
+	for _, s := range stats {
+		scores[s.Id] = engagementScore(s)
+	}
+	return scores
+}
+
+// userSignals weighs articles by the user's feedback: ratings count
+// fully, reading time counts half
+func userSignals(ds *DataStore, user User) map[bson.ObjectId]float64 {
+	signals := make(map[bson.ObjectId]float64)
+	var reads []Event
+	ds.C("Events").Find(bson.M{"user": user.Id, "action": actionRead}).Sort("-timestamp").Limit(200).All(&reads)
+	for _, e := range reads {
+		if e.Read != nil {
+			signals[e.Article] += 0.5 * e.Read.Weight
+		}
+	}
+	for _, id := range user.LikeNews {
+		signals[id] = 1
+	}
+	for _, id := range user.DislikeNews {
+		signals[id] = -1
+	}
+	return signals
+}
+
+// recommendedArticles ranks recent articles of the user's tags by tag
+// preference and by relation to articles the user liked or read
+func recommendedArticles(ds *DataStore, user User, keep func(Article) bool, window int) ([]Article, error) {
+	now := time.Now()
+	var articles []Article
+	err := ds.C("Articles").Find(bson.M{"tags": bson.M{"$in": user.Tags}, "timestamp": bson.M{"$gte": now.Add(-recommendWindow)}}).
+		All(&articles)
+	if err != nil {
+		return nil, err
+	}
+	articles = keepArticles(articles, keep)
+	weights := tagWeights(ds, user)
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	signals := userSignals(ds, user)
+	scores := make(map[bson.ObjectId]float64)
+	var ranked []Article
+	for _, a := range articles {
+		if signals[a.Id] < 0 {
+			continue
+		}
+		s := 0.0
+		for _, t := range a.Tags {
+			s += weights[t] / total
+		}
+		for _, rel := range a.Related {
+			s += signals[rel.Id] * rel.Score
+		}
+		scores[a.Id] = s * math.Exp(-now.Sub(a.Timestamp).Hours()/recommendDecay.Hours())
+		ranked = append(ranked, a)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i].Id] > scores[ranked[j].Id] })
+	s := user.Diversity.withDefaults()
+	if s.MaxRun > 0 && len(ranked) > window {
+		ranked = append(capRuns(ranked[:window], s.MaxRun), ranked[window:]...)
+	} else if s.MaxRun > 0 {
+		ranked = capRuns(ranked, s.MaxRun)
+	}
+	return ranked, nil
+}
+
+// discoveryArticles is the latest feed with popular articles of tags the
+// user doesn't follow mixed in
+func discoveryArticles(ds *DataStore, user User, keep func(Article) bool, window int) ([]Article, error) {
+	own, err := latestArticles(ds, user, keep, window)
+	if err != nil {
+		return nil, err
+	}
+	var other []Article
+	err = ds.C("Articles").Find(bson.M{"tags": bson.M{"$nin": user.Tags}, "timestamp": bson.M{"$gte": time.Now().Add(-discoveryWindow)}}).
+		All(&other)
+	if err != nil {
+		return nil, err
+	}
+	other = keepArticles(other, keep)
+	scores := statsScores(ds, other)
+	sort.SliceStable(other, func(i, j int) bool {
+		if scores[other[i].Id] != scores[other[j].Id] {
+			return scores[other[i].Id] > scores[other[j].Id]
+		}
+		return other[i].Timestamp.After(other[j].Timestamp)
+	})
+	var mixed []Article
+	for len(own) > 0 || len(other) > 0 {
+		if len(other) > 0 && (len(own) == 0 || (len(mixed)+1)%discoveryEvery == 0) {
+			mixed = append(mixed, other[0])
+			other = other[1:]
+		} else {
+			mixed = append(mixed, own[0])
+			own = own[1:]
+		}
+	}
+	return mixed, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// kinds of mute rules
+const (
+	MuteKeyword = "keyword"
+	MuteRegexp  = "regexp"
+	MuteSource  = "source"
+	MuteTag     = "tag"
+)
+
+const maxMuteValueLen = 200
+
+type MuteRule struct {
+	Id    bson.ObjectId `bson:"_id"`
+	Kind  string        `bson:"kind"`
+	Value string        `bson:"value"`
+}
+
+type muteMatcher struct {
+	rule MuteRule
+	re   *regexp.Regexp
+}
+
+// compileMutes prepares user mute rules for matching, broken rules are skipped
+func compileMutes(rules []MuteRule) []muteMatcher {
+	var m []muteMatcher
+	for _, r := range rules {
+		mm := muteMatcher{rule: r}
+		switch r.Kind {
+		case MuteKeyword:
+			mm.rule.Value = strings.ToLower(r.Value)
+		case MuteRegexp:
+			re, err := regexp.Compile("(?i)" + r.Value)
+			if err != nil {
+				continue
+			}
+			mm.re = re
+		}
+		m = append(m, mm)
+	}
+	return m
+}
+
+// mutedBy returns the first rule which hides the article or nil
+func mutedBy(mutes []muteMatcher, article Article) *MuteRule {
+	for i := range mutes {
+		m := &mutes[i]
+		switch m.rule.Kind {
+		case MuteKeyword:
+			if strings.Contains(strings.ToLower(article.Title), m.rule.Value) ||
+				strings.Contains(strings.ToLower(article.Text), m.rule.Value) {
+				return &mutes[i].rule
+			}
+		case MuteRegexp:
+			if m.re.MatchString(article.Title) || m.re.MatchString(article.Text) {
+				return &mutes[i].rule
+			}
+		case MuteSource:
+			if article.Source == m.rule.Value {
+				return &mutes[i].rule
+			}
+		case MuteTag:
+			for _, t := range article.Tags {
+				if t == m.rule.Value {
+					return &mutes[i].rule
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validMuteRule(kind, value string) bool {
+	if value == "" || len(value) > maxMuteValueLen {
+		return false
+	}
+	switch kind {
+	case MuteKeyword, MuteSource, MuteTag:
+		return true
+	case MuteRegexp:
+		_, err := regexp.Compile(value)
+		return err == nil
+	}
+	return false
+}
+
+func mutesList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	if user.Mutes == nil {
+		user.Mutes = []MuteRule{}
+	}
+	respondWithJSON(w, http.StatusOK, user.Mutes)
+}
+
+func muteAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Users")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	err = req.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't parse form")
+		return
+	}
+	rule := MuteRule{
+		Id:    bson.NewObjectId(),
+		Kind:  req.FormValue("kind"),
+		Value: strings.TrimSpace(req.FormValue("value")),
+	}
+	if !validMuteRule(rule.Kind, rule.Value) {
+		respondWithError(w, http.StatusBadRequest, "Invalid mute rule")
+		return
+	}
+	err = c.Update(bson.M{"_id": user.Id}, bson.M{"$push": bson.M{"mutes": rule}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't add mute rule")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, rule)
+}
+
+func muteDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Users")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = c.Update(bson.M{"_id": user.Id}, bson.M{"$pull": bson.M{"mutes": bson.M{"_id": bson.ObjectIdHex(id)}}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete mute rule")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Snooze hides an article from the feed until Until, then brings it
+// back to the top for snoozeTop
+type Snooze struct {
+	Article bson.ObjectId `bson:"article"`
+	Until   time.Time     `bson:"until"`
+}
+
+const (
+	snoozeTop     = 24 * time.Hour
+	snoozeMorning = 8
+	maxPins       = 20
+)
+
+// snoozeUntil turns later, tomorrow or week into a time in the user's
+// time zone, week is the next monday morning
+func snoozeUntil(user User, when string, now time.Time) (time.Time, bool) {
+	loc, err := time.LoadLocation(user.Digest.TimeZone)
+	if err != nil || user.Digest.TimeZone == "" {
+		loc, _ = time.LoadLocation("Europe/Moscow")
+	}
+	now = now.In(loc)
+	morning := time.Date(now.Year(), now.Month(), now.Day(), snoozeMorning, 0, 0, 0, loc)
+	switch when {
+	case "later":
+		return now.Add(3 * time.Hour), true
+	case "tomorrow":
+		return morning.AddDate(0, 0, 1), true
+	case "week":
+		days := (8 - int(now.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return morning.AddDate(0, 0, days), true
+	}
+	return time.Time{}, false
+}
+
+// feedPins returns the pinned and woken up snoozed articles in the
+// order they top the feed, and the snoozed articles which are hidden
+func feedPins(user User, now time.Time) (top []bson.ObjectId, pinned, woken, hidden map[bson.ObjectId]bool) {
+	pinned = make(map[bson.ObjectId]bool)
+	woken = make(map[bson.ObjectId]bool)
+	hidden = make(map[bson.ObjectId]bool)
+	for i := len(user.Pins) - 1; i >= 0; i-- {
+		pinned[user.Pins[i]] = true
+		top = append(top, user.Pins[i])
+	}
+	for _, s := range user.Snoozes {
+		if pinned[s.Article] {
+			continue
+		}
+		if s.Until.After(now) {
+			hidden[s.Article] = true
+		} else if now.Sub(s.Until) < snoozeTop {
+			woken[s.Article] = true
+			top = append(top, s.Article)
+		}
+	}
+	return top, pinned, woken, hidden
+}
+
+// findArticles loads the articles of ids in the order of ids
+func findArticles(ds *DataStore, ids []bson.ObjectId) []Article {
+	var res []Article
+	ca := ds.C("Articles")
+	for _, id := range ids {
+		var a Article
+		if ca.FindId(id).One(&a) == nil {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// pinsAndSnoozes puts pinned and woken up snoozed articles at the top
+// of articles and drops snoozed ones, it returns the ids of both kinds
+func pinsAndSnoozes(ds *DataStore, user User, articles []Article, now time.Time) ([]Article, map[bson.ObjectId]bool, map[bson.ObjectId]bool) {
+	top, pinned, woken, hidden := feedPins(user, now)
+	if len(top) == 0 && len(hidden) == 0 {
+		return articles, pinned, woken
+	}
+	res := findArticles(ds, top)
+	for _, a := range articles {
+		if !pinned[a.Id] && !woken[a.Id] && !hidden[a.Id] {
+			res = append(res, a)
+		}
+	}
+	return res, pinned, woken
+}
+
+func snoozeAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Users")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	until, ok := snoozeUntil(user, req.FormValue("until"), time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown snooze time")
+		return
+	}
+	s := Snooze{bson.ObjectIdHex(id), until}
+	// snoozing again moves the time, expired snoozes are cleaned up
+	err = c.UpdateId(user.Id, bson.M{"$pull": bson.M{"snoozes": bson.M{"$or": []bson.M{
+		{"article": s.Article},
+		{"until": bson.M{"$lt": time.Now().Add(-snoozeTop)}},
+	}}}})
+	if err == nil {
+		err = c.UpdateId(user.Id, bson.M{"$push": bson.M{"snoozes": s}})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't snooze this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, s)
+}
+
+func snoozeDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Users").UpdateId(user.Id, bson.M{"$pull": bson.M{"snoozes": bson.M{"article": bson.ObjectIdHex(id)}}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete snooze")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+func pinAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	n, err := ds.C("Articles").FindId(bson.ObjectIdHex(id)).Count()
+	if err != nil || n == 0 {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+	// pinning again is fine even with all pins taken
+	if containsId(user.Pins, bson.ObjectIdHex(id)) {
+		respondWithJSON(w, http.StatusOK, "Successfully added")
+		return
+	}
+	err = ds.C("Users").Update(bson.M{
+		"_id":                             user.Id,
+		"pins":                            bson.M{"$ne": bson.ObjectIdHex(id)},
+		"pins." + strconv.Itoa(maxPins-1): bson.M{"$exists": false},
+	}, bson.M{"$push": bson.M{"pins": bson.ObjectIdHex(id)}})
+	if err == mgo.ErrNotFound {
+		// either pinned meanwhile or all pins are taken
+		n, _ := ds.C("Users").Find(bson.M{"_id": user.Id, "pins": bson.ObjectIdHex(id)}).Count()
+		if n == 0 {
+			respondWithError(w, http.StatusBadRequest, "Too many pinned articles")
+			return
+		}
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't pin this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully added")
+}
+
+func pinDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Users").UpdateId(user.Id, bson.M{"$pull": bson.M{"pins": bson.ObjectIdHex(id)}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't unpin this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
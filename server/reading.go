@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// characters of russian text read per second
+	readingSpeed = 20.0
+	// reports beyond this are a tab left open, not reading
+	maxDwell = 3 * 60 * 60
+	// shortest expected reading time, titles and teasers are read too
+	minExpectedRead = 10.0
+)
+
+// ReadSignal is implicit feedback from the reader view. Weight is in
+// [-1, 1], positive when the article was read through, negative when
+// it was closed right away.
+type ReadSignal struct {
+	Dwell   float64 `bson:"dwell"`
+	Scroll  float64 `bson:"scroll"`
+	TextLen int     `bson:"textLen"`
+	Weight  float64 `bson:"weight"`
+}
+
+// readWeight normalizes dwell time by the time the text takes to read
+// and combines it with the scroll depth
+func readWeight(textLen int, dwell, scroll float64) float64 {
+	expected := math.Max(float64(textLen)/readingSpeed, minExpectedRead)
+	completion := math.Min(dwell/expected, 1)
+	return completion + scroll - 1
+}
+
+func articleRead(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	if user.NoReadTracking {
+		respondWithJSON(w, http.StatusOK, "Tracking disabled")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	// ParseFloat takes "NaN" and "Inf", which slip through the ranges
+	dwell, err := strconv.ParseFloat(req.FormValue("dwell"), 64)
+	if err != nil || math.IsNaN(dwell) || math.IsInf(dwell, 0) || dwell < 0 || dwell > maxDwell {
+		respondWithError(w, http.StatusBadRequest, "Invalid dwell")
+		return
+	}
+	scroll, err := strconv.ParseFloat(req.FormValue("scroll"), 64)
+	if err != nil || math.IsNaN(scroll) || math.IsInf(scroll, 0) || scroll < 0 || scroll > 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid scroll")
+		return
+	}
+	var a Article
+	err = ds.C("Articles").FindId(bson.ObjectIdHex(id)).Select(bson.M{"textLen": 1}).One(&a)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+	e := newEvent(req, user, a.Id, actionRead)
+	e.Read = &ReadSignal{dwell, scroll, a.TextLen, readWeight(a.TextLen, dwell, scroll)}
+	err = ds.C("Events").Insert(e)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save signal")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully added")
+}
+
+func accountTrackingChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	disabled := req.FormValue("enabled") != "1"
+	err = ds.C("Users").Update(bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"noReadTracking": disabled}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save settings")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// kinds of Reason
+const (
+	reasonTag       = "tag"
+	reasonSource    = "source"
+	reasonSearch    = "search"
+	reasonSimilar   = "similar"
+	reasonTrending  = "trending"
+	reasonDiscovery = "discovery"
+	reasonShared    = "shared"
+	reasonFollowed  = "followed"
+)
+
+// Reason explains why an article is in the user's feed. Value is the
+// tag, the source, the saved search name or the name of a followed user
+// who liked the article, Article is the liked article of a similar
+// reason.
+type Reason struct {
+	Kind    string        `bson:"kind"`
+	Value   string        `bson:"value,omitempty"`
+	Article bson.ObjectId `bson:"article,omitempty"`
+}
+
+// reasoner holds what is needed to explain a page of the feed, so the
+// data is read once per request
+type reasoner struct {
+	user     User
+	searches []SavedSearch
+	liked    map[bson.ObjectId]bool
+	trending map[bson.ObjectId]bool
+	followed map[bson.ObjectId][]string
+}
+
+func newReasoner(ds *DataStore, user User) *reasoner {
+	r := &reasoner{
+		user:     user,
+		liked:    make(map[bson.ObjectId]bool),
+		trending: make(map[bson.ObjectId]bool),
+		followed: followedLikes(ds, user),
+	}
+	ds.C("SavedSearches").Find(bson.M{"user": user.Id}).All(&r.searches)
+	for _, id := range user.LikeNews {
+		r.liked[id] = true
+	}
+	var t Trending
+	if ds.C("Trending").FindId(trendingOverall).One(&t) == nil {
+		for _, a := range t.Articles {
+			r.trending[a.Id] = true
+		}
+	}
+	return r
+}
+
+func (r *reasoner) reasons(a Article) []Reason {
+	var reasons []Reason
+	for _, t := range a.Tags {
+		if contains(r.user.Tags, t) {
+			reasons = append(reasons, Reason{Kind: reasonTag, Value: t})
+		}
+	}
+	for _, s := range r.searches {
+		if !s.Query.Match(a) {
+			continue
+		}
+		// a saved search of only sources is following them
+		if len(s.Query.Sources) > 0 && s.Query.Text == "" && len(s.Query.Tags) == 0 {
+			reasons = append(reasons, Reason{Kind: reasonSource, Value: a.Source})
+		} else {
+			reasons = append(reasons, Reason{Kind: reasonSearch, Value: s.Name})
+		}
+	}
+	for _, rel := range a.Related {
+		if r.liked[rel.Id] {
+			reasons = append(reasons, Reason{Kind: reasonSimilar, Article: rel.Id})
+			break
+		}
+	}
+	if r.trending[a.Id] {
+		reasons = append(reasons, Reason{Kind: reasonTrending})
+	}
+	for _, name := range r.followed[a.Id] {
+		reasons = append(reasons, Reason{Kind: reasonFollowed, Value: name})
+	}
+	return reasons
+}
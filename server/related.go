@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Related is precomputed by articaleServer when an article is stored
+type Related struct {
+	Id    bson.ObjectId `bson:"id"`
+	Score float64       `bson:"score"`
+}
+
+const (
+	relatedDefault = 5
+	relatedMax     = 20
+)
+
+func articleRelated(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	ca := ds.C("Articles")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	limit := relatedDefault
+	if l := req.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if limit > relatedMax {
+			limit = relatedMax
+		}
+	}
+	var art Article
+	err = ca.FindId(bson.ObjectIdHex(id)).Select(bson.M{"related": 1}).One(&art)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	mutes := compileMutes(user.Mutes)
+	f := []ArticleFeed{}
+	for _, r := range art.Related {
+		var a Article
+		err = ca.FindId(r.Id).One(&a)
+		if err != nil || mutedBy(mutes, a) != nil {
+			continue
+		}
+		f = append(f, newArticleFeed(a, checked))
+		if len(f) == limit {
+			break
+		}
+	}
+	respondWithJSON(w, http.StatusOK, f)
+}
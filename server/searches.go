@@ -0,0 +1,353 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Query is matched against new articles by articaleServer
+type Query struct {
+	Text    string   `bson:"text"`
+	Sources []string `bson:"sources"`
+	Tags    []string `bson:"tags"`
+	// Entities are keys of Entity, set when the user follows an entity
+	Entities []string `bson:"entities,omitempty"`
+}
+
+// Match mirrors Query.Match of articaleServer for articles
+// which are pushed to live streams
+func (q Query) Match(a Article) bool {
+	if len(q.Sources) > 0 && !contains(q.Sources, a.Source) {
+		return false
+	}
+	if len(q.Entities) > 0 {
+		found := false
+		for _, e := range a.Entities {
+			if contains(q.Entities, e.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.Tags) > 0 {
+		found := false
+		for _, t := range a.Tags {
+			if contains(q.Tags, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Text != "" {
+		title := strings.ToLower(a.Title)
+		text := strings.ToLower(a.Text)
+		for _, w := range strings.Fields(strings.ToLower(q.Text)) {
+			if !strings.Contains(title, w) && !strings.Contains(text, w) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type SavedSearch struct {
+	Id      bson.ObjectId `bson:"_id,omitempty"`
+	User    bson.ObjectId `bson:"user"`
+	Name    string        `bson:"name"`
+	Query   Query         `bson:"query"`
+	Notify  []string      `bson:"notify"`
+	Webhook string        `bson:"webhook"`
+	Created time.Time     `bson:"created"`
+}
+
+type SearchMatch struct {
+	Id        bson.ObjectId `bson:"_id,omitempty"`
+	Search    bson.ObjectId `bson:"search"`
+	User      bson.ObjectId `bson:"user"`
+	Article   bson.ObjectId `bson:"article"`
+	Timestamp time.Time     `bson:"timestamp"`
+}
+
+type Notification struct {
+	Id        bson.ObjectId `bson:"_id,omitempty"`
+	User      bson.ObjectId `bson:"user"`
+	Search    bson.ObjectId `bson:"search"`
+	Name      string        `bson:"name"`
+	Article   bson.ObjectId `bson:"article"`
+	Title     string        `bson:"title"`
+	Link      string        `bson:"link"`
+	Read      bool          `bson:"read"`
+	Timestamp time.Time     `bson:"timestamp"`
+}
+
+const maxSavedSearches = 20
+
+var notifyChannels = []string{"inapp", "email", "webhook"}
+
+func searchesList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	searches := []SavedSearch{}
+	err = ds.C("SavedSearches").Find(bson.M{"user": user.Id}).Sort("created").All(&searches)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find saved searches")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, searches)
+}
+
+func searchAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("SavedSearches")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	err = req.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't parse form")
+		return
+	}
+	s := SavedSearch{
+		Id:   bson.NewObjectId(),
+		User: user.Id,
+		Name: strings.TrimSpace(req.FormValue("name")),
+		Query: Query{
+			Text:    strings.TrimSpace(req.FormValue("q")),
+			Sources: nonEmpty(req.Form["sources"]),
+			Tags:    nonEmpty(req.Form["tags"]),
+		},
+		Webhook: strings.TrimSpace(req.FormValue("webhook")),
+		Created: time.Now(),
+	}
+	if s.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name not specified")
+		return
+	}
+	if s.Query.Text == "" && len(s.Query.Sources) == 0 && len(s.Query.Tags) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Query is empty")
+		return
+	}
+	for _, ch := range req.Form["notify"] {
+		if !contains(notifyChannels, ch) {
+			respondWithError(w, http.StatusBadRequest, "Unknown notification channel")
+			return
+		}
+		s.Notify = append(s.Notify, ch)
+	}
+	if contains(s.Notify, "webhook") && !validWebhookURL(s.Webhook) {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook url")
+		return
+	}
+	n, err := c.Find(bson.M{"user": user.Id}).Count()
+	if err != nil || n >= maxSavedSearches {
+		respondWithError(w, http.StatusBadRequest, "Too many saved searches")
+		return
+	}
+	err = c.Insert(s)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save search")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, s)
+}
+
+func searchDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("SavedSearches").Remove(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete saved search")
+		return
+	}
+	ds.C("SearchMatches").RemoveAll(bson.M{"search": bson.ObjectIdHex(id)})
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// searchFeed is the live feed of a saved search, built from matches
+// which articaleServer records at ingestion time
+func searchFeed(w http.ResponseWriter, req *http.Request) {
+	var (
+		matches  []SearchMatch
+		articles []Article
+	)
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	vars := mux.Vars(req)
+	pageInt, err := strconv.Atoi(vars["page"])
+	if err != nil || !bson.IsObjectIdHex(vars["id"]) {
+		respondWithError(w, http.StatusBadRequest, "Can't find this page")
+		return
+	}
+	q := bson.M{"search": bson.ObjectIdHex(vars["id"]), "user": user.Id}
+	err = ds.C("SearchMatches").Find(q).Sort("-timestamp").Select(bson.M{"article": 1}).All(&matches)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find saved search")
+		return
+	}
+	var ids []bson.ObjectId
+	for _, m := range matches {
+		ids = append(ids, m.Article)
+	}
+	err = ds.C("Articles").Find(bson.M{"_id": bson.M{"$in": ids}}).All(&articles)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+	// the articles go in the order of the matches
+	byId := make(map[bson.ObjectId]Article)
+	for _, a := range articles {
+		byId[a.Id] = a
+	}
+	articles = articles[:0]
+	for _, id := range ids {
+		if a, ok := byId[id]; ok {
+			articles = append(articles, a)
+		}
+	}
+	writeArticlePage(w, ds, user, articles, pageInt, req.URL.Query().Get("muted") == "1")
+}
+
+func notificationsList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	q := bson.M{"user": user.Id}
+	if req.URL.Query().Get("all") != "1" {
+		q["read"] = false
+	}
+	notifications := []Notification{}
+	err = ds.C("Notifications").Find(q).Sort("-timestamp").Limit(50).All(&notifications)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find notifications")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, notifications)
+}
+
+func notificationsRead(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	_, err = ds.C("Notifications").UpdateAll(bson.M{"user": user.Id, "read": false}, bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't update notifications")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+// validWebhookURL reports whether s is an http url of a public host.
+// The host has to resolve to public addresses only, so a webhook can't
+// reach mongo or the other services of the docker network. articaleServer
+// checks the address again when it dials, the record may change.
+func validWebhookURL(s string) bool {
+	if !validHTTPURL(s) {
+		return false
+	}
+	u, _ := url.Parse(s)
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return publicIP(ip)
+	}
+	// single label names like mongo or localhost are internal
+	if !strings.Contains(host, ".") {
+		return false
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// cgnat is the shared address space of carrier NAT, it isn't routed on
+// the internet either
+var _, cgnat, _ = net.ParseCIDR("100.64.0.0/10")
+
+// publicIP reports whether ip is a routable internet address, which
+// rules out loopback, private, link-local and the like
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip))
+}
+
+// validHTTPURL reports whether s is an absolute http or https url
+func validHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func nonEmpty(list []string) []string {
+	var r []string
+	for _, i := range list {
+		i = strings.TrimSpace(i)
+		if i != "" {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+func contains(list []string, s string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
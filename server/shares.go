@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	maxShareMessage = 500
+	// shares a user may send within an hour
+	maxSharesPerHour = 20
+)
+
+// Share is an article sent by one user to the inbox of another
+type Share struct {
+	Id        bson.ObjectId `bson:"_id"`
+	From      bson.ObjectId `bson:"from"`
+	FromEmail string        `bson:"fromEmail"`
+	To        bson.ObjectId `bson:"to"`
+	Article   bson.ObjectId `bson:"article"`
+	Message   string        `bson:"message"`
+	Read      bool          `bson:"read"`
+	Created   time.Time     `bson:"created"`
+}
+
+// ShareView is an inbox entry with its article
+type ShareView struct {
+	Share
+	Article ArticleFeed
+}
+
+// ShareLink opens an article to anyone who has the token, Views counts
+// the openings
+type ShareLink struct {
+	Id       bson.ObjectId `bson:"_id"`
+	Token    string        `bson:"token"`
+	User     bson.ObjectId `bson:"user"`
+	Article  bson.ObjectId `bson:"article"`
+	Title    string        `bson:"title"`
+	Views    int           `bson:"views"`
+	LastView time.Time     `bson:"lastView,omitempty"`
+	Created  time.Time     `bson:"created"`
+}
+
+// PublicArticle is the read only article of a share link
+type PublicArticle struct {
+	Title     string
+	Link      string
+	TopImage  string
+	Source    string
+	Text      string
+	Timestamp time.Time
+}
+
+// shareArticle loads the user and the article of the {id} route variable
+func shareArticle(ds *DataStore, req *http.Request) (User, Article, string) {
+	var a Article
+	user, err := currentUser(ds, req)
+	if err != nil {
+		return user, a, "Can't find user"
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		return user, a, "Invalid id"
+	}
+	if ds.C("Articles").FindId(bson.ObjectIdHex(id)).One(&a) != nil {
+		return user, a, "Can't find any of article"
+	}
+	return user, a, ""
+}
+
+// shareAdd sends the article to the inbox of the user with the given
+// email. The answer is the same whether or not the email is registered,
+// and a letter only goes out if the recipient has nothing unread yet.
+func shareAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Shares")
+
+	user, a, msg := shareArticle(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.FormValue("email")))
+	if email == "" || email == strings.ToLower(user.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid recipient")
+		return
+	}
+	message := strings.TrimSpace(req.FormValue("message"))
+	if utf8.RuneCountInString(message) > maxShareMessage {
+		respondWithError(w, http.StatusBadRequest, "Message is too long")
+		return
+	}
+	n, err := c.Find(bson.M{"from": user.Id, "created": bson.M{"$gt": time.Now().Add(-time.Hour)}}).Count()
+	if err != nil || n >= maxSharesPerHour {
+		respondWithError(w, http.StatusTooManyRequests, "Too many shares")
+		return
+	}
+	var to User
+	if ds.C("Users").Find(bson.M{"email": email}).One(&to) != nil {
+		respondWithJSON(w, http.StatusOK, "Successfully shared")
+		return
+	}
+	s := Share{
+		Id:        bson.NewObjectId(),
+		From:      user.Id,
+		FromEmail: user.Email,
+		To:        to.Id,
+		Article:   a.Id,
+		Message:   message,
+		Created:   time.Now(),
+	}
+	unread, _ := c.Find(bson.M{"to": to.Id, "read": false}).Count()
+	err = c.Insert(s)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't share article")
+		return
+	}
+	recordEvent(ds, req, user, a.Id, actionShare)
+	if unread == 0 {
+		go func() {
+			text := fmt.Sprintf("%s делится с вами статьей «%s».\n", s.FromEmail, a.Title)
+			if s.Message != "" {
+				text += "\n" + s.Message + "\n"
+			}
+			text += fmt.Sprintf("\nЧитать: %s/article/%s\n", publicURL, a.Id.Hex())
+			err := newMailer().Send(Mail{To: to.Email, Subject: "Статья от " + s.FromEmail, Text: text})
+			if err != nil {
+				log.Println("share mail err: ", err)
+			}
+		}()
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully shared")
+}
+
+func inboxList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var shares []Share
+	err = ds.C("Shares").Find(bson.M{"to": user.Id}).Sort("-created").Limit(100).All(&shares)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find shares")
+		return
+	}
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	res := []ShareView{}
+	ca := ds.C("Articles")
+	for _, s := range shares {
+		var a Article
+		if ca.FindId(s.Article).One(&a) != nil {
+			continue
+		}
+		res = append(res, ShareView{s, newArticleFeed(a, checked)})
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func inboxDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Shares").Remove(bson.M{"_id": bson.ObjectIdHex(id), "to": user.Id})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find share")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// markSharesRead marks the shares of the article to the user as read
+func markSharesRead(ds *DataStore, user User, id bson.ObjectId) {
+	_, err := ds.C("Shares").UpdateAll(bson.M{"to": user.Id, "article": id, "read": false}, bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		log.Println("shares update err: ", err)
+	}
+}
+
+// sharedArticles puts the articles shared with the user and not read yet
+// at the top of articles, it returns the sender of each of them
+func sharedArticles(ds *DataStore, user User, articles []Article) ([]Article, map[bson.ObjectId]string) {
+	from := make(map[bson.ObjectId]string)
+	var shares []Share
+	ds.C("Shares").Find(bson.M{"to": user.Id, "read": false}).Sort("-created").Limit(20).All(&shares)
+	if len(shares) == 0 {
+		return articles, from
+	}
+	var res []Article
+	ca := ds.C("Articles")
+	for _, s := range shares {
+		if from[s.Article] != "" {
+			continue
+		}
+		var a Article
+		if ca.FindId(s.Article).One(&a) == nil {
+			from[s.Article] = s.FromEmail
+			res = append(res, a)
+		}
+	}
+	for _, a := range articles {
+		if from[a.Id] == "" {
+			res = append(res, a)
+		}
+	}
+	return res, from
+}
+
+// shareLinkAdd returns the share link of the article, the link is made
+// on the first call
+func shareLinkAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("ShareLinks")
+
+	user, a, msg := shareArticle(ds, req)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	var l ShareLink
+	if c.Find(bson.M{"user": user.Id, "article": a.Id}).One(&l) == nil {
+		respondWithJSON(w, http.StatusOK, l)
+		return
+	}
+	l = ShareLink{
+		Id:      bson.NewObjectId(),
+		Token:   randomToken(),
+		User:    user.Id,
+		Article: a.Id,
+		Title:   a.Title,
+		Created: time.Now(),
+	}
+	err := c.Insert(l)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save link")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, l)
+}
+
+func shareLinksList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	links := []ShareLink{}
+	err = ds.C("ShareLinks").Find(bson.M{"user": user.Id}).Sort("-created").All(&links)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find links")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+func shareLinkDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	err = ds.C("ShareLinks").Remove(bson.M{"token": mux.Vars(req)["token"], "user": user.Id})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find link")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// sharedArticle serves the article of a share link without auth and
+// counts the view
+func sharedArticle(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("ShareLinks")
+
+	token := mux.Vars(req)["token"]
+	var l ShareLink
+	if token == "" || c.Find(bson.M{"token": token}).One(&l) != nil {
+		respondWithError(w, http.StatusNotFound, "Can't find link")
+		return
+	}
+	var a Article
+	if ds.C("Articles").FindId(l.Article).One(&a) != nil {
+		respondWithError(w, http.StatusNotFound, "Can't find any of article")
+		return
+	}
+	err := c.UpdateId(l.Id, bson.M{"$inc": bson.M{"views": 1}, "$set": bson.M{"lastView": time.Now()}})
+	if err != nil {
+		log.Println("share link views err: ", err)
+	}
+	respondWithJSON(w, http.StatusOK, PublicArticle{a.Title, a.Link, a.TopImage, a.Source, a.Text, a.Timestamp})
+}
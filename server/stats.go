@@ -0,0 +1,263 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ArticleStats counts users who interacted with an article
+type ArticleStats struct {
+	Id        bson.ObjectId `bson:"_id"`
+	Source    string        `bson:"source"`
+	Likes     int           `bson:"likes"`
+	Dislikes  int           `bson:"dislikes"`
+	Opens     int           `bson:"opens"`
+	Bookmarks int           `bson:"bookmarks"`
+}
+
+type SourceStats struct {
+	Source    string  `bson:"_id"`
+	Articles  int     `bson:"articles"`
+	Likes     int     `bson:"likes"`
+	Dislikes  int     `bson:"dislikes"`
+	Opens     int     `bson:"opens"`
+	Bookmarks int     `bson:"bookmarks"`
+	LikeRatio float64 `bson:"-"`
+	Poor      bool    `bson:"-"`
+}
+
+// a source is poor when its ratings are mostly dislikes
+// or its articles are hardly ever opened
+const (
+	poorMinRatings = 10
+	poorLikeRatio  = 0.3
+	poorOpenRate   = 0.1
+)
+
+// countEngagement increments a counter of ArticleStats, field is one of
+// likes, dislikes, opens or bookmarks, n may be negative
+func countEngagement(ds *DataStore, id bson.ObjectId, field string, n int) {
+	var a Article
+	err := ds.C("Articles").FindId(id).Select(bson.M{"source": 1}).One(&a)
+	if err != nil {
+		return
+	}
+	_, err = ds.C("ArticleStats").UpsertId(id, bson.M{
+		"$inc": bson.M{field: n},
+		"$set": bson.M{"source": a.Source},
+	})
+	if err != nil {
+		log.Println("stats update err: ", err)
+	}
+}
+
+// countOpen logs every opening and counts the first one of a user
+func countOpen(ds *DataStore, req *http.Request, user User, id bson.ObjectId) {
+	recordEvent(ds, req, user, id, actionOpen)
+	err := ds.C("Opens").Insert(bson.M{"_id": id.Hex() + user.Id.Hex(), "article": id, "user": user.Id})
+	if err == nil {
+		countEngagement(ds, id, "opens", 1)
+	} else if !mgo.IsDup(err) {
+		log.Println("opens insert err: ", err)
+	}
+}
+
+func articleStats(ds *DataStore, id bson.ObjectId) ArticleStats {
+	s := ArticleStats{Id: id}
+	ds.C("ArticleStats").FindId(id).One(&s)
+	return s
+}
+
+// backfillStats fills ArticleStats from the rating arrays of users once,
+// it runs before the server takes requests. The counters are set rather
+// than added to, so counts written before the migration existed aren't
+// doubled.
+func backfillStats() {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	err := ds.C("Migrations").Insert(bson.M{"_id": "stats-backfill", "started": time.Now()})
+	if mgo.IsDup(err) {
+		return
+	} else if err != nil {
+		log.Println("stats backfill err: ", err)
+		return
+	}
+	for field, counter := range map[string]string{"likeNews": "likes", "dislikeNews": "dislikes", "bookmarks": "bookmarks"} {
+		counts, err := ratingCounts(ds, field)
+		if err != nil {
+			log.Println("stats backfill err: ", err)
+			return
+		}
+		for id, c := range counts {
+			setEngagement(ds, id, counter, c)
+		}
+	}
+}
+
+// setEngagement is countEngagement setting the counter to n
+func setEngagement(ds *DataStore, id bson.ObjectId, field string, n int) {
+	var a Article
+	err := ds.C("Articles").FindId(id).Select(bson.M{"source": 1}).One(&a)
+	if err != nil {
+		return
+	}
+	_, err = ds.C("ArticleStats").UpsertId(id, bson.M{"$set": bson.M{field: n, "source": a.Source}})
+	if err != nil {
+		log.Println("stats backfill err: ", err)
+	}
+}
+
+// sourcesStats sums the engagement of the articles of every source, the
+// number of articles comes from Articles since articles nobody touched
+// have no stats
+func sourcesStats(ds *DataStore) ([]SourceStats, error) {
+	var sources, engaged []SourceStats
+	err := ds.C("Articles").Pipe([]bson.M{
+		{"$group": bson.M{"_id": "$source", "articles": bson.M{"$sum": 1}}},
+	}).All(&sources)
+	if err != nil {
+		return nil, err
+	}
+	err = ds.C("ArticleStats").Pipe([]bson.M{
+		{"$group": bson.M{
+			"_id":       "$source",
+			"likes":     bson.M{"$sum": "$likes"},
+			"dislikes":  bson.M{"$sum": "$dislikes"},
+			"opens":     bson.M{"$sum": "$opens"},
+			"bookmarks": bson.M{"$sum": "$bookmarks"},
+		}},
+	}).All(&engaged)
+	if err != nil {
+		return nil, err
+	}
+	bySource := make(map[string]*SourceStats)
+	for i := range sources {
+		bySource[sources[i].Source] = &sources[i]
+	}
+	for _, e := range engaged {
+		if s, ok := bySource[e.Source]; ok {
+			s.Likes, s.Dislikes, s.Opens, s.Bookmarks = e.Likes, e.Dislikes, e.Opens, e.Bookmarks
+		}
+	}
+	for i := range sources {
+		s := &sources[i]
+		ratings := s.Likes + s.Dislikes
+		if ratings > 0 {
+			s.LikeRatio = float64(s.Likes) / float64(ratings)
+		}
+		s.Poor = (ratings >= poorMinRatings && s.LikeRatio < poorLikeRatio) ||
+			(s.Articles >= poorMinRatings && float64(s.Opens)/float64(s.Articles) < poorOpenRate)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].LikeRatio < sources[j].LikeRatio })
+	return sources, nil
+}
+
+// adminHandler lets only users with the admin flag through
+func adminHandler(next http.HandlerFunc) http.HandlerFunc {
+	return restrictedHandler(func(w http.ResponseWriter, req *http.Request) {
+		ds := NewDataStore()
+		defer ds.Close()
+		user, err := currentUser(ds, req)
+		if err != nil || !user.Admin {
+			respondWithError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+		next(w, req)
+	})
+}
+
+func adminSources(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	sources, err := sourcesStats(ds)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't count sources")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, sources)
+}
+
+func bookmarkAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Users").Update(bson.M{"_id": user.Id, "bookmarks": bson.M{"$ne": bson.ObjectIdHex(id)}},
+		bson.M{"$push": bson.M{"bookmarks": bson.ObjectIdHex(id)}})
+	if err == nil {
+		countEngagement(ds, bson.ObjectIdHex(id), "bookmarks", 1)
+		recordEvent(ds, req, user, bson.ObjectIdHex(id), actionBookmark)
+	} else if err != mgo.ErrNotFound {
+		respondWithError(w, http.StatusBadRequest, "Can't add this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully added")
+}
+
+func bookmarkDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Users").Update(bson.M{"_id": user.Id, "bookmarks": bson.ObjectIdHex(id)},
+		bson.M{"$pull": bson.M{"bookmarks": bson.ObjectIdHex(id)}})
+	if err == nil {
+		countEngagement(ds, bson.ObjectIdHex(id), "bookmarks", -1)
+		recordEvent(ds, req, user, bson.ObjectIdHex(id), actionUnbookmark)
+	} else if err != mgo.ErrNotFound {
+		respondWithError(w, http.StatusBadRequest, "Can't delete this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+func bookmarks(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	ca := ds.C("Articles")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	f := []ArticleFeed{}
+	for i := len(user.Bookmarks) - 1; i >= 0; i-- {
+		var a Article
+		err = ca.FindId(user.Bookmarks[i]).One(&a)
+		if err != nil {
+			continue
+		}
+		f = append(f, newArticleFeed(a, checked))
+	}
+	respondWithJSON(w, http.StatusOK, f)
+}
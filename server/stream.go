@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// hub fans new articles out to the open streams
+type hub struct {
+	mu      sync.Mutex
+	streams map[chan Article]bool
+}
+
+var articlesHub = &hub{streams: make(map[chan Article]bool)}
+
+func (h *hub) subscribe() chan Article {
+	ch := make(chan Article, 16)
+	h.mu.Lock()
+	h.streams[ch] = true
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *hub) unsubscribe(ch chan Article) {
+	h.mu.Lock()
+	delete(h.streams, ch)
+	h.mu.Unlock()
+}
+
+// publish never blocks, slow streams lose articles
+func (h *hub) publish(a Article) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.streams {
+		select {
+		case ch <- a:
+		default:
+		}
+	}
+}
+
+const (
+	streamBackoff    = 5 * time.Second
+	streamMaxBackoff = 5 * time.Minute
+)
+
+// consumeArticles reads ids which articaleServer publishes to the
+// "articles" exchange and passes the articles to the hub, it reconnects
+// with backoff whenever rabbitmq goes away
+func consumeArticles() {
+	backoff := streamBackoff
+	for {
+		start := time.Now()
+		err := consumeOnce()
+		log.Print("rabbitmq consumer err: ", err)
+		// a consumer which has been running for a while starts over
+		if time.Since(start) > streamMaxBackoff {
+			backoff = streamBackoff
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// consumeOnce consumes articles until the connection or the channel is
+// closed
+func consumeOnce() error {
+	rabbitConn, err := amqp.Dial("amqp://rabbitmq:5672")
+	if err != nil {
+		return err
+	}
+	defer rabbitConn.Close()
+	ch, err := rabbitConn.Channel()
+	if err != nil {
+		return fmt.Errorf("channel: %v", err)
+	}
+	defer ch.Close()
+	err = ch.ExchangeDeclare("articles", "fanout", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("exchange: %v", err)
+	}
+	q, err := ch.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("queue: %v", err)
+	}
+	err = ch.QueueBind(q.Name, "", "articles", false, nil)
+	if err != nil {
+		return fmt.Errorf("bind: %v", err)
+	}
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume: %v", err)
+	}
+
+	ds := NewDataStore()
+	defer ds.Close()
+	ca := ds.C("Articles")
+	for m := range msgs {
+		id := bson.ObjectId(m.Body)
+		if !id.Valid() {
+			continue
+		}
+		var a Article
+		err = ca.FindId(id).One(&a)
+		if err != nil {
+			log.Println("stream article err: ", err)
+			continue
+		}
+		articlesHub.publish(a)
+	}
+	return fmt.Errorf("consumer closed")
+}
+
+func streamMatches(user User, searches []SavedSearch, mutes []muteMatcher, a Article) bool {
+	if mutedBy(mutes, a) != nil {
+		return false
+	}
+	for _, t := range a.Tags {
+		if contains(user.Tags, t) {
+			return true
+		}
+	}
+	for _, s := range searches {
+		if s.Query.Match(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// stream pushes the user's new articles as server-sent events
+func stream(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+	ds := NewDataStore()
+	user, err := currentUser(ds, req)
+	if err != nil {
+		ds.Close()
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var searches []SavedSearch
+	ds.C("SavedSearches").Find(bson.M{"user": user.Id}).All(&searches)
+	r := newReasoner(ds, user)
+	ds.Close()
+	mutes := compileMutes(user.Mutes)
+
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+
+	ch := articlesHub.subscribe()
+	defer articlesHub.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case a := <-ch:
+			if !streamMatches(user, searches, mutes, a) {
+				continue
+			}
+			af := newArticleFeed(a, checked)
+			af.Reasons = r.reasons(a)
+			data, err := json.Marshal(af)
+			if err != nil {
+				log.Println("json marshal: ", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: article\ndata: %s\n\n", a.Id.Hex(), data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TagDoc is a tag of the taxonomy. Slug is the value stored in users,
+// articles and filters, Aliases are old or misspelled slugs which are
+// resolved to it, Translations maps a language code to a display name.
+type TagDoc struct {
+	Slug         string            `bson:"_id"`
+	Name         string            `bson:"name"`
+	Aliases      []string          `bson:"aliases"`
+	Translations map[string]string `bson:"translations"`
+	Parent       string            `bson:"parent,omitempty"`
+}
+
+type TagView struct {
+	TagDoc
+	Children []string
+}
+
+var slugRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// defaultTags replaces the tag lists of frontEnd and articaleServer,
+// "programing" was the slug used before the taxonomy
+var defaultTags = []TagDoc{
+	{Slug: "it", Name: "IT", Translations: map[string]string{"en": "IT"}},
+	{Slug: "programming", Name: "Программирование", Aliases: []string{"programing"}, Translations: map[string]string{"en": "Programming"}, Parent: "it"},
+	{Slug: "popular-science", Name: "Научпоп", Translations: map[string]string{"en": "Popular science"}},
+	{Slug: "space", Name: "Космос", Translations: map[string]string{"en": "Space"}, Parent: "popular-science"},
+}
+
+// tagFields are the collections and fields holding tag slugs
+var tagFields = []struct{ collection, field string }{
+	{"Users", "tags"},
+	{"Articles", "tags"},
+	{"Workspaces", "tags"},
+	{"SavedSearches", "query.tags"},
+	{"Webhooks", "filter.tags"},
+}
+
+// tagElems are the arrays of documents holding a tag slug in field,
+// match picks the documents which hold a tag
+var tagElems = []struct {
+	collection, array, field string
+	match                    bson.M
+}{
+	{"Users", "mutes", "value", bson.M{"kind": MuteTag}},
+	{"Articles", "autoTags", "tag", nil},
+}
+
+// migrateTagElems renames the tag in the documents of the array, a
+// document which would repeat one already there is dropped. The
+// positional operator changes one document per array at a time, so the
+// update is repeated until nothing matches.
+func migrateTagElems(c *mgo.Collection, array, field string, match bson.M, from, to string) error {
+	elem := func(tag string) bson.M {
+		m := bson.M{field: tag}
+		for k, v := range match {
+			m[k] = v
+		}
+		return m
+	}
+	_, err := c.UpdateAll(bson.M{"$and": []bson.M{
+		{array: bson.M{"$elemMatch": elem(from)}},
+		{array: bson.M{"$elemMatch": elem(to)}},
+	}}, bson.M{"$pull": bson.M{array: elem(from)}})
+	if err != nil {
+		return err
+	}
+	for {
+		info, err := c.UpdateAll(bson.M{array: bson.M{"$elemMatch": elem(from)}},
+			bson.M{"$set": bson.M{array + ".$." + field: to}})
+		if err != nil {
+			return err
+		}
+		if info.Updated == 0 {
+			return nil
+		}
+	}
+}
+
+// migrateTag replaces the tag from by to everywhere it is stored
+func migrateTag(ds *DataStore, from, to string) error {
+	for _, f := range tagElems {
+		if err := migrateTagElems(ds.C(f.collection), f.array, f.field, f.match, from, to); err != nil {
+			return err
+		}
+	}
+	if err := migrateTagFeedback(ds, from, to); err != nil {
+		return err
+	}
+	for _, f := range tagFields {
+		c := ds.C(f.collection)
+		_, err := c.UpdateAll(bson.M{f.field: from}, bson.M{"$addToSet": bson.M{f.field: to}})
+		if err != nil {
+			return err
+		}
+		_, err = c.UpdateAll(bson.M{f.field: from}, bson.M{"$pull": bson.M{f.field: from}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTagFeedback moves the votes to the tag to, the id of a vote
+// holds its tag so the votes are saved again under new ids. A vote the
+// user has already given on to is kept.
+func migrateTagFeedback(ds *DataStore, from, to string) error {
+	c := ds.C("TagFeedback")
+	var f TagFeedback
+	iter := c.Find(bson.M{"tag": from}).Iter()
+	for iter.Next(&f) {
+		old := f.Id
+		f.Id = f.Article.Hex() + f.User.Hex() + to
+		f.Tag = to
+		if err := c.Insert(f); err != nil && !mgo.IsDup(err) {
+			iter.Close()
+			return err
+		}
+		if err := c.RemoveId(old); err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// seedTags fills the Tags collection and moves the data to the
+// canonical slugs once
+func seedTags() {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	ds.C("Tags").EnsureIndex(mgo.Index{Key: []string{"aliases"}})
+	err := ds.C("Migrations").Insert(bson.M{"_id": "tags-seed", "started": time.Now()})
+	if mgo.IsDup(err) {
+		return
+	} else if err != nil {
+		log.Println("tags seed err: ", err)
+		return
+	}
+	for _, t := range defaultTags {
+		err := ds.C("Tags").Insert(t)
+		if err != nil && !mgo.IsDup(err) {
+			log.Println("tags seed err: ", err)
+		}
+		for _, a := range t.Aliases {
+			if err := migrateTag(ds, a, t.Slug); err != nil {
+				log.Println("tags seed err: ", err)
+			}
+		}
+	}
+}
+
+// tagExists reports whether s is the slug or an alias of a tag other
+// than except
+func tagExists(ds *DataStore, s, except string) bool {
+	n, _ := ds.C("Tags").Find(bson.M{"$or": []bson.M{{"_id": s}, {"aliases": s}}, "_id": bson.M{"$ne": except}}).Count()
+	return n > 0
+}
+
+// validParent reports whether parent can be the parent of slug, which
+// it can't be if slug is one of its ancestors
+func validParent(ds *DataStore, slug, parent string) bool {
+	for i := 0; parent != "" && i < 20; i++ {
+		if parent == slug {
+			return false
+		}
+		var t TagDoc
+		if ds.C("Tags").FindId(parent).One(&t) != nil {
+			return false
+		}
+		parent = t.Parent
+	}
+	return parent == ""
+}
+
+func tagsList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	var list []TagDoc
+	err := ds.C("Tags").Find(nil).Sort("_id").All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find tags")
+		return
+	}
+	children := make(map[string][]string)
+	for _, t := range list {
+		if t.Parent != "" {
+			children[t.Parent] = append(children[t.Parent], t.Slug)
+		}
+	}
+	res := []TagView{}
+	for _, t := range list {
+		res = append(res, TagView{t, children[t.Slug]})
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// tagSave creates or changes a tag, new aliases take over the data of
+// the tags they name
+func tagSave(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	req.ParseForm()
+	t := TagDoc{
+		Slug:         strings.TrimSpace(req.FormValue("slug")),
+		Name:         strings.TrimSpace(req.FormValue("name")),
+		Parent:       strings.TrimSpace(req.FormValue("parent")),
+		Translations: make(map[string]string),
+	}
+	if !slugRe.MatchString(t.Slug) || t.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+	for _, a := range nonEmpty(req.Form["aliases"]) {
+		if !slugRe.MatchString(a) || a == t.Slug || tagExists(ds, a, t.Slug) {
+			respondWithError(w, http.StatusBadRequest, "Invalid alias")
+			return
+		}
+		t.Aliases = append(t.Aliases, a)
+	}
+	for _, tr := range nonEmpty(req.Form["translations"]) {
+		kv := strings.SplitN(tr, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid translation")
+			return
+		}
+		t.Translations[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	if n, _ := ds.C("Tags").Find(bson.M{"aliases": t.Slug}).Count(); n > 0 {
+		respondWithError(w, http.StatusBadRequest, "Slug is an alias")
+		return
+	}
+	if !validParent(ds, t.Slug, t.Parent) {
+		respondWithError(w, http.StatusBadRequest, "Invalid parent")
+		return
+	}
+	_, err := ds.C("Tags").UpsertId(t.Slug, t)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save tag")
+		return
+	}
+	for _, a := range t.Aliases {
+		if err := migrateTag(ds, a, t.Slug); err != nil {
+			log.Println("tag migrate err: ", err)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, t)
+}
+
+// tagRename gives a tag a new slug, the old one becomes an alias
+func tagRename(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Tags")
+
+	var t TagDoc
+	if c.FindId(mux.Vars(req)["slug"]).One(&t) != nil {
+		respondWithError(w, http.StatusNotFound, "Can't find tag")
+		return
+	}
+	to := strings.TrimSpace(req.FormValue("to"))
+	if !slugRe.MatchString(to) || tagExists(ds, to, t.Slug) {
+		respondWithError(w, http.StatusBadRequest, "Invalid slug")
+		return
+	}
+	from := t.Slug
+	t.Slug = to
+	t.Aliases = append(t.Aliases, from)
+	var aliases []string
+	for _, a := range t.Aliases {
+		if a != to {
+			aliases = append(aliases, a)
+		}
+	}
+	t.Aliases = aliases
+	err := c.Insert(t)
+	if err == nil {
+		err = c.RemoveId(from)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't rename tag")
+		return
+	}
+	c.UpdateAll(bson.M{"parent": from}, bson.M{"$set": bson.M{"parent": to}})
+	err = migrateTag(ds, from, to)
+	if err != nil {
+		log.Println("tag migrate err: ", err)
+	}
+	respondWithJSON(w, http.StatusOK, t)
+}
+
+// tagMerge moves a tag into another one, its slug and aliases become
+// aliases of the target and its children move under the target
+func tagMerge(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Tags")
+
+	var t, into TagDoc
+	if c.FindId(mux.Vars(req)["slug"]).One(&t) != nil || c.FindId(req.FormValue("into")).One(&into) != nil || t.Slug == into.Slug {
+		respondWithError(w, http.StatusNotFound, "Can't find tag")
+		return
+	}
+	err := c.RemoveId(t.Slug)
+	if err == nil {
+		err = c.UpdateId(into.Slug, bson.M{"$addToSet": bson.M{"aliases": bson.M{"$each": append([]string{t.Slug}, t.Aliases...)}}})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't merge tag")
+		return
+	}
+	c.UpdateAll(bson.M{"parent": t.Slug, "_id": bson.M{"$ne": into.Slug}}, bson.M{"$set": bson.M{"parent": into.Slug}})
+	// the target could be a child of the merged tag
+	c.UpdateAll(bson.M{"_id": into.Slug, "parent": t.Slug}, bson.M{"$set": bson.M{"parent": t.Parent}})
+	err = migrateTag(ds, t.Slug, into.Slug)
+	if err != nil {
+		log.Println("tag migrate err: ", err)
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully merged")
+}
+
+// canonicalTags resolves aliases to slugs and drops duplicates
+func canonicalTags(ds *DataStore, tags []string) []string {
+	var docs []TagDoc
+	ds.C("Tags").Find(bson.M{"aliases": bson.M{"$in": tags}}).All(&docs)
+	slug := make(map[string]string)
+	for _, d := range docs {
+		for _, a := range d.Aliases {
+			slug[a] = d.Slug
+		}
+	}
+	var res []string
+	for _, t := range tags {
+		if s, ok := slug[t]; ok {
+			t = s
+		}
+		if !contains(res, t) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// AutoTag is a tag assigned to an article by the classifier of
+// articaleServer, apart from the tags of its source
+type AutoTag struct {
+	Tag        string  `bson:"tag"`
+	Confidence float64 `bson:"confidence"`
+}
+
+// TagFeedback is a user's vote on whether a tag fits an article, the
+// classifier learns from the votes on its next training
+type TagFeedback struct {
+	Id      string        `bson:"_id"`
+	Article bson.ObjectId `bson:"article"`
+	User    bson.ObjectId `bson:"user"`
+	Tag     string        `bson:"tag"`
+	Correct bool          `bson:"correct"`
+	Created time.Time     `bson:"created"`
+}
+
+func autoTagFeedback(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	tag := req.FormValue("tag")
+	if n, _ := ds.C("Tags").FindId(tag).Count(); n == 0 {
+		respondWithError(w, http.StatusBadRequest, "Unknown tag")
+		return
+	}
+	if n, _ := ds.C("Articles").FindId(bson.ObjectIdHex(id)).Count(); n == 0 {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+	f := TagFeedback{
+		Id:      id + user.Id.Hex() + tag,
+		Article: bson.ObjectIdHex(id),
+		User:    user.Id,
+		Tag:     tag,
+		Correct: req.FormValue("correct") == "1",
+		Created: time.Now(),
+	}
+	_, err = ds.C("TagFeedback").UpsertId(f.Id, f)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save feedback")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully saved")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ratings older than the window don't count
+	trendingWindow  = 48 * time.Hour
+	trendingSize    = 50
+	trendingGravity = 1.5
+	trendingOverall = "_all"
+)
+
+type TrendingArticle struct {
+	Id       bson.ObjectId `bson:"id"`
+	Score    float64       `bson:"score"`
+	Likes    int           `bson:"likes"`
+	Dislikes int           `bson:"dislikes"`
+}
+
+// Trending is the ranked list of one tag, trendingOverall for all tags
+type Trending struct {
+	Tag      string            `bson:"_id"`
+	Articles []TrendingArticle `bson:"articles"`
+	Updated  time.Time         `bson:"updated"`
+}
+
+// trendingScheduler recomputes the Trending collection, it never returns
+func trendingScheduler() {
+	ds := NewDataStore()
+	ds.C("Events").EnsureIndex(mgo.Index{Key: []string{"action", "timestamp"}})
+	ds.Close()
+	computeTrending(time.Now())
+	timer := time.NewTicker(time.Minute * 15)
+	for range timer.C {
+		computeTrending(time.Now())
+	}
+}
+
+// ratingCounts counts likes or dislikes per article over all users
+func ratingCounts(ds *DataStore, field string) (map[bson.ObjectId]int, error) {
+	var res []struct {
+		Id bson.ObjectId `bson:"_id"`
+		N  int           `bson:"n"`
+	}
+	err := ds.C("Users").Pipe([]bson.M{
+		{"$project": bson.M{field: 1}},
+		{"$unwind": "$" + field},
+		{"$group": bson.M{"_id": "$" + field, "n": bson.M{"$sum": 1}}},
+	}).All(&res)
+	counts := make(map[bson.ObjectId]int)
+	for _, r := range res {
+		counts[r.Id] = r.N
+	}
+	return counts, err
+}
+
+// trendingVelocity is the rate of likes and dislikes rated in the window
+// per article, each rating decays by the time since it was given. Only
+// the last rating of a user counts, so an unrate takes a like back.
+func trendingVelocity(ds *DataStore, now time.Time) (map[bson.ObjectId]*TrendingArticle, error) {
+	var res []struct {
+		Id struct {
+			Article bson.ObjectId `bson:"article"`
+			User    bson.ObjectId `bson:"user"`
+		} `bson:"_id"`
+		Action    string    `bson:"action"`
+		Timestamp time.Time `bson:"timestamp"`
+	}
+	err := ds.C("Events").Pipe([]bson.M{
+		{"$match": bson.M{
+			"action":    bson.M{"$in": []string{actionLike, actionDislike, actionUnrate}},
+			"timestamp": bson.M{"$gte": now.Add(-trendingWindow)},
+		}},
+		{"$sort": bson.M{"timestamp": 1}},
+		{"$group": bson.M{
+			"_id":       bson.M{"article": "$article", "user": "$user"},
+			"action":    bson.M{"$last": "$action"},
+			"timestamp": bson.M{"$last": "$timestamp"},
+		}},
+	}).AllowDiskUse().All(&res)
+	if err != nil {
+		return nil, err
+	}
+	velocity := make(map[bson.ObjectId]*TrendingArticle)
+	for _, r := range res {
+		if r.Action == actionUnrate {
+			continue
+		}
+		t := velocity[r.Id.Article]
+		if t == nil {
+			t = &TrendingArticle{Id: r.Id.Article}
+			velocity[r.Id.Article] = t
+		}
+		decay := math.Pow(now.Sub(r.Timestamp).Hours()+2, trendingGravity)
+		if r.Action == actionLike {
+			t.Likes++
+			t.Score += 1 / decay
+		} else {
+			t.Dislikes++
+			t.Score -= 1 / decay
+		}
+	}
+	return velocity, nil
+}
+
+func computeTrending(now time.Time) {
+	// mongo keeps milliseconds, see the cleanup below
+	now = now.Truncate(time.Millisecond)
+	ds := NewDataStore()
+	defer ds.Close()
+
+	velocity, err := trendingVelocity(ds, now)
+	if err != nil {
+		log.Println("trending events err: ", err)
+		return
+	}
+	var ids []bson.ObjectId
+	for id, t := range velocity {
+		if t.Score > 0 {
+			ids = append(ids, id)
+		}
+	}
+	var articles []Article
+	err = ds.C("Articles").Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"tags": 1}).All(&articles)
+	if err != nil {
+		log.Println("trending articles err: ", err)
+		return
+	}
+
+	byTag := make(map[string][]TrendingArticle)
+	for _, a := range articles {
+		t := *velocity[a.Id]
+		byTag[trendingOverall] = append(byTag[trendingOverall], t)
+		for _, tag := range a.Tags {
+			byTag[tag] = append(byTag[tag], t)
+		}
+	}
+	c := ds.C("Trending")
+	for tag, list := range byTag {
+		sort.Slice(list, func(i, j int) bool { return list[i].Score > list[j].Score })
+		if len(list) > trendingSize {
+			list = list[:trendingSize]
+		}
+		_, err = c.UpsertId(tag, Trending{tag, list, now})
+		if err != nil {
+			log.Println("trending upsert err: ", err)
+		}
+	}
+	// tags without recently liked articles drop out
+	c.RemoveAll(bson.M{"updated": bson.M{"$lt": now}})
+}
+
+func trending(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	ca := ds.C("Articles")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	tag := req.URL.Query().Get("tag")
+	if tag == "" {
+		tag = trendingOverall
+	}
+	limit := 20
+	if l := req.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > trendingSize {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+	f := []ArticleFeed{}
+	var t Trending
+	err = ds.C("Trending").FindId(tag).One(&t)
+	if err != nil {
+		respondWithJSON(w, http.StatusOK, f)
+		return
+	}
+
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	mutes := compileMutes(user.Mutes)
+	r := newReasoner(ds, user)
+	for _, ta := range t.Articles {
+		var a Article
+		err = ca.FindId(ta.Id).One(&a)
+		if err != nil || mutedBy(mutes, a) != nil {
+			continue
+		}
+		af := newArticleFeed(a, checked)
+		af.Reasons = r.reasons(a)
+		f = append(f, af)
+		if len(f) == limit {
+			break
+		}
+	}
+	respondWithJSON(w, http.StatusOK, f)
+}
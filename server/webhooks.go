@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Webhook is called by articaleServer for every new article matching Filter
+type Webhook struct {
+	Id       bson.ObjectId `bson:"_id,omitempty"`
+	User     bson.ObjectId `bson:"user"`
+	URL      string        `bson:"url"`
+	Secret   string        `bson:"secret"`
+	Filter   Query         `bson:"filter"`
+	Failures int           `bson:"failures"`
+	Disabled bool          `bson:"disabled"`
+	Created  time.Time     `bson:"created"`
+}
+
+type WebhookDelivery struct {
+	Id        bson.ObjectId `bson:"_id,omitempty"`
+	Webhook   bson.ObjectId `bson:"webhook"`
+	Article   bson.ObjectId `bson:"article"`
+	Attempt   int           `bson:"attempt"`
+	Status    int           `bson:"status"`
+	Error     string        `bson:"error"`
+	Duration  time.Duration `bson:"duration"`
+	Timestamp time.Time     `bson:"timestamp"`
+}
+
+const maxWebhooks = 10
+
+func webhooksList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	hooks := []Webhook{}
+	err = ds.C("Webhooks").Find(bson.M{"user": user.Id}).Sort("created").All(&hooks)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find webhooks")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, hooks)
+}
+
+func webhookAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+	c := ds.C("Webhooks")
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	err = req.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't parse form")
+		return
+	}
+	h := Webhook{
+		Id:     bson.NewObjectId(),
+		User:   user.Id,
+		URL:    strings.TrimSpace(req.FormValue("url")),
+		Secret: randomToken(),
+		Filter: Query{
+			Text:    strings.TrimSpace(req.FormValue("q")),
+			Sources: nonEmpty(req.Form["sources"]),
+			Tags:    nonEmpty(req.Form["tags"]),
+		},
+		Created: time.Now(),
+	}
+	if !validWebhookURL(h.URL) {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook url")
+		return
+	}
+	n, err := c.Find(bson.M{"user": user.Id}).Count()
+	if err != nil || n >= maxWebhooks {
+		respondWithError(w, http.StatusBadRequest, "Too many webhooks")
+		return
+	}
+	err = c.Insert(h)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save webhook")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, h)
+}
+
+func webhookDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err = ds.C("Webhooks").Remove(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete webhook")
+		return
+	}
+	ds.C("WebhookDeliveries").RemoveAll(bson.M{"webhook": bson.ObjectIdHex(id)})
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// webhookEnable turns an automatically disabled webhook back on
+func webhookEnable(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	var h Webhook
+	err = ds.C("Webhooks").Find(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id}).One(&h)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't enable webhook")
+		return
+	}
+	// hooks saved before urls were checked against internal hosts
+	if !validWebhookURL(h.URL) {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook url")
+		return
+	}
+	err = ds.C("Webhooks").UpdateId(h.Id, bson.M{"$set": bson.M{"disabled": false, "failures": 0}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't enable webhook")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully enabled")
+}
+
+func webhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	n, err := ds.C("Webhooks").Find(bson.M{"_id": bson.ObjectIdHex(id), "user": user.Id}).Count()
+	if err != nil || n == 0 {
+		respondWithError(w, http.StatusBadRequest, "Can't find webhook")
+		return
+	}
+	deliveries := []WebhookDelivery{}
+	err = ds.C("WebhookDeliveries").Find(bson.M{"webhook": bson.ObjectIdHex(id)}).Sort("-timestamp").Limit(50).All(&deliveries)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find deliveries")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// roles of workspace members, every role can do what the next ones can
+const (
+	roleOwner  = "owner"
+	roleAdmin  = "admin"
+	roleMember = "member"
+)
+
+const (
+	maxWorkspaceMembers = 100
+	maxMustRead         = 100
+	invitationTTL       = 14 * 24 * time.Hour
+)
+
+type Member struct {
+	User   bson.ObjectId `bson:"user"`
+	Email  string        `bson:"email"`
+	Role   string        `bson:"role"`
+	Joined time.Time     `bson:"joined"`
+}
+
+type MustRead struct {
+	Article bson.ObjectId `bson:"article"`
+	By      string        `bson:"by"`
+	Note    string        `bson:"note"`
+	Added   time.Time     `bson:"added"`
+}
+
+// Workspace shares a tag and source configuration between its members.
+// An empty Sources takes articles of every source.
+type Workspace struct {
+	Id       bson.ObjectId `bson:"_id"`
+	Name     string        `bson:"name"`
+	Tags     []string      `bson:"tags"`
+	Sources  []string      `bson:"sources"`
+	Members  []Member      `bson:"members"`
+	MustRead []MustRead    `bson:"mustRead"`
+	Created  time.Time     `bson:"created"`
+}
+
+type Invitation struct {
+	Id        bson.ObjectId `bson:"_id"`
+	Workspace bson.ObjectId `bson:"workspace"`
+	Name      string        `bson:"name"`
+	Email     string        `bson:"email"`
+	Role      string        `bson:"role"`
+	Token     string        `bson:"token"`
+	By        string        `bson:"by"`
+	Created   time.Time     `bson:"created"`
+}
+
+// TeamRating is the rating of an article by a workspace member
+type TeamRating struct {
+	Email  string
+	Rating string
+}
+
+// MustReadView is an entry of the must read list with its article
+type MustReadView struct {
+	MustRead
+	Article ArticleFeed
+}
+
+type WorkspaceView struct {
+	Workspace
+	Me       bson.ObjectId
+	Role     string
+	MustRead []MustReadView
+}
+
+func roleRank(role string) int {
+	switch role {
+	case roleOwner:
+		return 3
+	case roleAdmin:
+		return 2
+	case roleMember:
+		return 1
+	}
+	return 0
+}
+
+func (ws Workspace) member(id bson.ObjectId) (Member, bool) {
+	for _, m := range ws.Members {
+		if m.User == id {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+// workspaceAccess finds the workspace {id} for a member with at least role
+func workspaceAccess(ds *DataStore, req *http.Request, role string) (User, Workspace, int, string) {
+	var ws Workspace
+	user, err := currentUser(ds, req)
+	if err != nil {
+		return user, ws, http.StatusBadRequest, "Can't find user"
+	}
+	id := mux.Vars(req)["id"]
+	if !bson.IsObjectIdHex(id) {
+		return user, ws, http.StatusBadRequest, "Invalid id"
+	}
+	err = ds.C("Workspaces").Find(bson.M{"_id": bson.ObjectIdHex(id), "members.user": user.Id}).One(&ws)
+	if err != nil {
+		return user, ws, http.StatusBadRequest, "Can't find workspace"
+	}
+	m, _ := ws.member(user.Id)
+	if roleRank(m.Role) < roleRank(role) {
+		return user, ws, http.StatusForbidden, "Access denied"
+	}
+	return user, ws, 0, ""
+}
+
+func workspacesList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	list := []Workspace{}
+	err = ds.C("Workspaces").Find(bson.M{"members.user": user.Id}).Select(bson.M{"mustRead": 0}).Sort("name").All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find workspaces")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+func workspaceAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	ws := Workspace{
+		Id:       bson.NewObjectId(),
+		Name:     strings.TrimSpace(req.FormValue("name")),
+		Tags:     user.Tags,
+		Members:  []Member{{user.Id, user.Email, roleOwner, time.Now()}},
+		MustRead: []MustRead{},
+		Created:  time.Now(),
+	}
+	if ws.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name not specified")
+		return
+	}
+	err = ds.C("Workspaces").Insert(ws)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save workspace")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ws)
+}
+
+func workspaceGet(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, ws, code, msg := workspaceAccess(ds, req, roleMember)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	m, _ := ws.member(user.Id)
+	v := WorkspaceView{Workspace: ws, Me: user.Id, Role: m.Role, MustRead: []MustReadView{}}
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	ratings := teamRatings(ds, ws)
+	ca := ds.C("Articles")
+	for i := len(ws.MustRead) - 1; i >= 0; i-- {
+		var a Article
+		if ca.FindId(ws.MustRead[i].Article).One(&a) != nil {
+			continue
+		}
+		af := newArticleFeed(a, checked)
+		af.Team = ratings(a.Id)
+		v.MustRead = append(v.MustRead, MustReadView{ws.MustRead[i], af})
+	}
+	v.Workspace.MustRead = nil
+	respondWithJSON(w, http.StatusOK, v)
+}
+
+// workspaceChange sets the name, tags and sources of the workspace
+func workspaceChange(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, ws, code, msg := workspaceAccess(ds, req, roleAdmin)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	req.ParseForm()
+	name := strings.TrimSpace(req.FormValue("name"))
+	if name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name not specified")
+		return
+	}
+	err := ds.C("Workspaces").UpdateId(ws.Id, bson.M{"$set": bson.M{
+		"name":    name,
+		"tags":    nonEmpty(req.Form["tags"]),
+		"sources": nonEmpty(req.Form["sources"]),
+	}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't update workspace")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+func workspaceDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, ws, code, msg := workspaceAccess(ds, req, roleOwner)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	err := ds.C("Workspaces").RemoveId(ws.Id)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete workspace")
+		return
+	}
+	ds.C("Invitations").RemoveAll(bson.M{"workspace": ws.Id})
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// workspaceInvite invites an email address, the letter is only a
+// reminder, the invitation shows up for the user on the site
+func workspaceInvite(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, ws, code, msg := workspaceAccess(ds, req, roleAdmin)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	inv := Invitation{
+		Id:        bson.NewObjectId(),
+		Workspace: ws.Id,
+		Name:      ws.Name,
+		Email:     strings.ToLower(strings.TrimSpace(req.FormValue("email"))),
+		Role:      req.FormValue("role"),
+		Token:     randomToken(),
+		By:        user.Email,
+		Created:   time.Now(),
+	}
+	if inv.Role == "" {
+		inv.Role = roleMember
+	}
+	if inv.Role != roleMember && inv.Role != roleAdmin {
+		respondWithError(w, http.StatusBadRequest, "Unknown role")
+		return
+	}
+	if !strings.Contains(inv.Email, "@") {
+		respondWithError(w, http.StatusBadRequest, "Invalid email")
+		return
+	}
+	for _, m := range ws.Members {
+		if strings.EqualFold(m.Email, inv.Email) {
+			respondWithError(w, http.StatusBadRequest, "Already a member")
+			return
+		}
+	}
+	if len(ws.Members) >= maxWorkspaceMembers {
+		respondWithError(w, http.StatusBadRequest, "Too many members")
+		return
+	}
+	_, err := ds.C("Invitations").RemoveAll(bson.M{"workspace": ws.Id, "email": inv.Email})
+	if err == nil {
+		err = ds.C("Invitations").Insert(inv)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't save invitation")
+		return
+	}
+	go func() {
+		err := newMailer().Send(Mail{
+			To:      inv.Email,
+			Subject: "Приглашение в " + ws.Name,
+			Text: fmt.Sprintf("%s приглашает вас в рабочее пространство «%s» на NeFeed.\n\nПринять приглашение: %s/workspaces\n",
+				inv.By, ws.Name, publicURL),
+		})
+		if err != nil {
+			log.Println("invitation mail err: ", err)
+		}
+	}()
+	respondWithJSON(w, http.StatusOK, inv)
+}
+
+// invitationsList shows the pending invitations of the user
+func invitationsList(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	list := []Invitation{}
+	err = ds.C("Invitations").Find(bson.M{
+		"email":   strings.ToLower(user.Email),
+		"created": bson.M{"$gte": time.Now().Add(-invitationTTL)},
+	}).All(&list)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find invitations")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+// invitationAccept adds the user to the workspace, the invitation must
+// be addressed to the user's email
+func invitationAccept(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, err := currentUser(ds, req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find user")
+		return
+	}
+	var inv Invitation
+	_, err = ds.C("Invitations").Find(bson.M{
+		"token":   mux.Vars(req)["token"],
+		"email":   strings.ToLower(user.Email),
+		"created": bson.M{"$gte": time.Now().Add(-invitationTTL)},
+	}).Apply(mgo.Change{Remove: true}, &inv)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find invitation")
+		return
+	}
+	err = ds.C("Workspaces").Update(bson.M{"_id": inv.Workspace, "members.user": bson.M{"$ne": user.Id}},
+		bson.M{"$push": bson.M{"members": Member{user.Id, user.Email, inv.Role, time.Now()}}})
+	if err != nil && err != mgo.ErrNotFound {
+		respondWithError(w, http.StatusBadRequest, "Can't join workspace")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, inv)
+}
+
+// memberDelete removes a member, admins remove others, anyone but the
+// owner may leave
+func memberDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, ws, code, msg := workspaceAccess(ds, req, roleMember)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	id := mux.Vars(req)["user"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	me, _ := ws.member(user.Id)
+	m, ok := ws.member(bson.ObjectIdHex(id))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Can't find member")
+		return
+	}
+	if m.Role == roleOwner || (m.User != user.Id && roleRank(me.Role) <= roleRank(m.Role)) {
+		respondWithError(w, http.StatusForbidden, "Access denied")
+		return
+	}
+	err := ds.C("Workspaces").UpdateId(ws.Id, bson.M{"$pull": bson.M{"members": bson.M{"user": m.User}}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't remove member")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// memberRole makes a member an admin or back, only the owner can
+func memberRole(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, ws, code, msg := workspaceAccess(ds, req, roleOwner)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	id := mux.Vars(req)["user"]
+	role := req.FormValue("role")
+	if !bson.IsObjectIdHex(id) || (role != roleAdmin && role != roleMember) {
+		respondWithError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+	err := ds.C("Workspaces").Update(
+		bson.M{"_id": ws.Id, "members": bson.M{"$elemMatch": bson.M{"user": bson.ObjectIdHex(id), "role": bson.M{"$ne": roleOwner}}}},
+		bson.M{"$set": bson.M{"members.$.role": role}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't change role")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully updated")
+}
+
+func mustReadAdd(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, ws, code, msg := workspaceAccess(ds, req, roleMember)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	id := req.FormValue("article")
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	n, err := ds.C("Articles").FindId(bson.ObjectIdHex(id)).Count()
+	if err != nil || n == 0 {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+	if len(ws.MustRead) >= maxMustRead {
+		respondWithError(w, http.StatusBadRequest, "Must read list is full")
+		return
+	}
+	mr := MustRead{bson.ObjectIdHex(id), user.Email, strings.TrimSpace(req.FormValue("note")), time.Now()}
+	err = ds.C("Workspaces").Update(bson.M{"_id": ws.Id, "mustRead.article": bson.M{"$ne": mr.Article}},
+		bson.M{"$push": bson.M{"mustRead": mr}})
+	if err != nil && err != mgo.ErrNotFound {
+		respondWithError(w, http.StatusBadRequest, "Can't add this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully added")
+}
+
+func mustReadDelete(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	_, ws, code, msg := workspaceAccess(ds, req, roleMember)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	id := mux.Vars(req)["article"]
+	if !bson.IsObjectIdHex(id) {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	err := ds.C("Workspaces").UpdateId(ws.Id, bson.M{"$pull": bson.M{"mustRead": bson.M{"article": bson.ObjectIdHex(id)}}})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't delete this article")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Successfully deleted")
+}
+
+// teamRatings loads the ratings of the members once and returns a
+// lookup by article
+func teamRatings(ds *DataStore, ws Workspace) func(bson.ObjectId) []TeamRating {
+	ids := make([]bson.ObjectId, len(ws.Members))
+	for i, m := range ws.Members {
+		ids[i] = m.User
+	}
+	var members []User
+	ds.C("Users").Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"email": 1, "likeNews": 1, "dislikeNews": 1}).All(&members)
+	ratings := make(map[bson.ObjectId][]TeamRating)
+	for _, u := range members {
+		for _, id := range u.LikeNews {
+			ratings[id] = append(ratings[id], TeamRating{u.Email, "like"})
+		}
+		for _, id := range u.DislikeNews {
+			ratings[id] = append(ratings[id], TeamRating{u.Email, "dislike"})
+		}
+	}
+	return func(id bson.ObjectId) []TeamRating {
+		return ratings[id]
+	}
+}
+
+// workspaceFeed is feed with the tags and sources of the workspace,
+// the requesting member's mutes and rated marks still apply
+func workspaceFeed(w http.ResponseWriter, req *http.Request) {
+	ds := NewDataStore()
+	defer ds.Close()
+
+	user, ws, code, msg := workspaceAccess(ds, req, roleMember)
+	if msg != "" {
+		respondWithError(w, code, msg)
+		return
+	}
+	pageInt, err := strconv.Atoi(mux.Vars(req)["page"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find this page")
+		return
+	}
+	team := user
+	team.Tags = ws.Tags
+	mutes := compileMutes(user.Mutes)
+	// the sources and mutes go first so the re-ranking sees the feed
+	// the members get
+	keep := func(a Article) bool {
+		return (len(ws.Sources) == 0 || contains(ws.Sources, a.Source)) && mutedBy(mutes, a) == nil
+	}
+	articles, err := modeArticles(ds, team, req.URL.Query().Get("mode"), req.URL.Query().Get("period"), keep, rerankWindow(10*(pageInt+1)))
+	if err == errUnknownMode || err == errUnknownPeriod {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't find any of article")
+		return
+	}
+
+	var checked []bson.ObjectId
+	checked = append(checked, user.LikeNews...)
+	checked = append(checked, user.DislikeNews...)
+	r := newReasoner(ds, team)
+	ratings := teamRatings(ds, ws)
+	f := []ArticleFeed{}
+	for _, article := range articles {
+		a := newArticleFeed(article, checked)
+		a.Reasons = r.reasons(article)
+		a.Team = ratings(article.Id)
+		f = append(f, a)
+	}
+	writeFeedPage(w, ds, user, f, pageInt)
+}